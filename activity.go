@@ -0,0 +1,303 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// A webhook registered with the Account Activity API
+type Webhook struct {
+	Id               string
+	Url              string
+	Valid            bool
+	CreatedTimestamp string `json:"created_timestamp"`
+}
+
+// The webhooks registered for an Account Activity environment
+type WebhookEnvironment struct {
+	EnvironmentName string `json:"environment_name"`
+	Webhooks        []Webhook
+}
+
+// The users subscribed to an Account Activity environment
+type SubscriptionList struct {
+	Environment   string
+	ApplicationId string `json:"application_id"`
+	Subscriptions []Subscription
+}
+
+type Subscription struct {
+	UserId string `json:"user_id"`
+}
+
+// Registers a webhook url for env. Twitter sends it a CRC challenge,
+// which must be answered, eg. by a WebhookHandler, before it is accepted.
+func (t *Twitter) RegisterWebhook(env, webhookUrl string) (webhook Webhook, err error) {
+	return t.RegisterWebhookContext(context.Background(), env, webhookUrl)
+}
+
+// RegisterWebhook with a context for cancellation and deadlines
+func (t *Twitter) RegisterWebhookContext(ctx context.Context, env, webhookUrl string) (webhook Webhook, err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "webhooks.json?url="+encode(webhookUrl)),
+		Method: "POST",
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &webhook)
+	return
+}
+
+// Returns the webhooks registered for every environment. Uses the
+// application-only bearer token.
+func (t *Twitter) AllWebhooks() (envs []WebhookEnvironment, err error) {
+	return t.AllWebhooksContext(context.Background())
+}
+
+// AllWebhooks with a context for cancellation and deadlines
+func (t *Twitter) AllWebhooksContext(ctx context.Context) (envs []WebhookEnvironment, err error) {
+	method := &RestMethod{
+		Url:    t.apiUrl("account_activity/all/webhooks.json"),
+		Method: "GET",
+		auth:   bearerAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	var result = struct {
+		Environments []WebhookEnvironment
+	}{}
+
+	err = json.Unmarshal(body, &result)
+	return result.Environments, err
+}
+
+// Returns the webhooks registered for env. Uses the application-only
+// bearer token.
+func (t *Twitter) Webhooks(env string) (webhooks []Webhook, err error) {
+	return t.WebhooksContext(context.Background(), env)
+}
+
+// Webhooks with a context for cancellation and deadlines
+func (t *Twitter) WebhooksContext(ctx context.Context, env string) (webhooks []Webhook, err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "webhooks.json"),
+		Method: "GET",
+		auth:   bearerAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &webhooks)
+	return
+}
+
+// Has Twitter send the webhook a CRC challenge, re-enabling it if it
+// was marked invalid
+func (t *Twitter) TriggerCRC(env, webhookId string) error {
+	return t.TriggerCRCContext(context.Background(), env, webhookId)
+}
+
+// TriggerCRC with a context for cancellation and deadlines
+func (t *Twitter) TriggerCRCContext(ctx context.Context, env, webhookId string) (err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "webhooks/"+encode(webhookId)+".json"),
+		Method: "PUT",
+	}
+
+	_, err = t.sendRestRequest(ctx, method)
+	return
+}
+
+// Removes a webhook, along with its subscriptions
+func (t *Twitter) DeleteWebhook(env, webhookId string) error {
+	return t.DeleteWebhookContext(context.Background(), env, webhookId)
+}
+
+// DeleteWebhook with a context for cancellation and deadlines
+func (t *Twitter) DeleteWebhookContext(ctx context.Context, env, webhookId string) (err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "webhooks/"+encode(webhookId)+".json"),
+		Method: "DELETE",
+	}
+
+	_, err = t.sendRestRequest(ctx, method)
+	return
+}
+
+// Subscribes the authenticated user's activity to env's webhook
+func (t *Twitter) Subscribe(env string) error {
+	return t.SubscribeContext(context.Background(), env)
+}
+
+// Subscribe with a context for cancellation and deadlines
+func (t *Twitter) SubscribeContext(ctx context.Context, env string) (err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "subscriptions.json"),
+		Method: "POST",
+	}
+
+	_, err = t.sendRestRequest(ctx, method)
+	return
+}
+
+// Reports whether the authenticated user is subscribed to env
+func (t *Twitter) IsSubscribed(env string) (bool, error) {
+	return t.IsSubscribedContext(context.Background(), env)
+}
+
+// IsSubscribed with a context for cancellation and deadlines
+func (t *Twitter) IsSubscribedContext(ctx context.Context, env string) (bool, error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "subscriptions.json"),
+		Method: "GET",
+	}
+
+	_, err := t.sendRestRequest(ctx, method)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Returns the users subscribed to env. Uses the application-only
+// bearer token.
+func (t *Twitter) Subscriptions(env string) (list SubscriptionList, err error) {
+	return t.SubscriptionsContext(context.Background(), env)
+}
+
+// Subscriptions with a context for cancellation and deadlines
+func (t *Twitter) SubscriptionsContext(ctx context.Context, env string) (list SubscriptionList, err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "subscriptions/list.json"),
+		Method: "GET",
+		auth:   bearerAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &list)
+	return
+}
+
+// Removes a user's subscription to env. Uses the application-only
+// bearer token.
+func (t *Twitter) Unsubscribe(env, userId string) error {
+	return t.UnsubscribeContext(context.Background(), env, userId)
+}
+
+// Unsubscribe with a context for cancellation and deadlines
+func (t *Twitter) UnsubscribeContext(ctx context.Context, env, userId string) (err error) {
+	method := &RestMethod{
+		Url:    t.activityUrl(env, "subscriptions/"+encode(userId)+".json"),
+		Method: "DELETE",
+		auth:   bearerAuth,
+	}
+
+	_, err = t.sendRestRequest(ctx, method)
+	return
+}
+
+// Makes sure webhookUrl is registered for env, for running on every
+// deploy. An existing registration is kept, and re-validated if it was
+// marked invalid. Webhooks for other urls are left alone unless the
+// environment is at its webhook limit, in which case the oldest of them
+// is deleted to make room. On the free tier, which allows one webhook,
+// that replaces the previous deployment's webhook.
+func (t *Twitter) EnsureWebhook(env, webhookUrl string) (Webhook, error) {
+	return t.EnsureWebhookContext(context.Background(), env, webhookUrl)
+}
+
+// EnsureWebhook with a context for cancellation and deadlines
+func (t *Twitter) EnsureWebhookContext(ctx context.Context, env, webhookUrl string) (webhook Webhook, err error) {
+	webhooks, err := t.WebhooksContext(ctx, env)
+	if err != nil {
+		return
+	}
+
+	for _, w := range webhooks {
+		if w.Url == webhookUrl {
+			if !w.Valid {
+				if err = t.TriggerCRCContext(ctx, env, w.Id); err != nil {
+					return
+				}
+				w.Valid = true
+			}
+			return w, nil
+		}
+	}
+
+	webhook, err = t.RegisterWebhookContext(ctx, env, webhookUrl)
+	if !tooManyWebhooks(err) {
+		return
+	}
+
+	var oldest *Webhook
+	for i, w := range webhooks {
+		if oldest == nil || w.CreatedTimestamp < oldest.CreatedTimestamp {
+			oldest = &webhooks[i]
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	if err = t.DeleteWebhookContext(ctx, env, oldest.Id); err != nil {
+		return
+	}
+	return t.RegisterWebhookContext(ctx, env, webhookUrl)
+}
+
+// Reports whether err is Twitter refusing to register a webhook because
+// the environment already has as many as it allows
+func tooManyWebhooks(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && e.HasCode(ErrCodeTooManyResources)
+}
+
+// Subscribes the authenticated user to env unless they already are
+func (t *Twitter) EnsureSubscription(env string) error {
+	return t.EnsureSubscriptionContext(context.Background(), env)
+}
+
+// EnsureSubscription with a context for cancellation and deadlines
+func (t *Twitter) EnsureSubscriptionContext(ctx context.Context, env string) error {
+	subscribed, err := t.IsSubscribedContext(ctx, env)
+	if err != nil || subscribed {
+		return err
+	}
+	return t.SubscribeContext(ctx, env)
+}
+
+// Returns the url of an Account Activity endpoint in env
+func (t *Twitter) activityUrl(env, path string) string {
+	return t.apiUrl("account_activity/all/" + encode(env) + "/" + path)
+}
@@ -0,0 +1,247 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// Starts a webhook for tt that answers CRC challenges unless broken is set
+func newWebhookServer(tt *Twitter, broken *int32) *httptest.Server {
+	h := tt.NewWebhookHandler()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(broken) != 0 {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	var broken int32
+	hook := newWebhookServer(tt, &broken)
+	defer hook.Close()
+
+	webhook, err := tt.RegisterWebhook("dev", hook.URL+"/webhook")
+	if err != nil {
+		t.Fatal("Error registering webhook:", err.Error())
+	}
+	if webhook.Id == "" || webhook.Url != hook.URL+"/webhook" || !webhook.Valid {
+		t.Errorf("Registered webhook was not returned correctly: %+v", webhook)
+	}
+
+	webhooks, err := tt.Webhooks("dev")
+	if err != nil {
+		t.Fatal("Error listing webhooks:", err.Error())
+	}
+	if len(webhooks) != 1 || webhooks[0] != webhook {
+		t.Errorf("Expected dev webhooks [%+v], got %+v", webhook, webhooks)
+	}
+
+	envs, err := tt.AllWebhooks()
+	if err != nil {
+		t.Fatal("Error listing all webhooks:", err.Error())
+	}
+	if len(envs) != 1 || envs[0].EnvironmentName != "dev" || len(envs[0].Webhooks) != 1 {
+		t.Errorf("Expected one dev webhook, got %+v", envs)
+	}
+
+	atomic.StoreInt32(&broken, 1)
+	if err := tt.TriggerCRC("dev", webhook.Id); err == nil {
+		t.Error("CRC check passed with the webhook down")
+	}
+	if webhooks := s.Webhooks("dev"); webhooks[0].Valid {
+		t.Error("Webhook was still valid after a failed CRC check")
+	}
+
+	atomic.StoreInt32(&broken, 0)
+	if err := tt.TriggerCRC("dev", webhook.Id); err != nil {
+		t.Error("Error triggering CRC check:", err.Error())
+	}
+	if webhooks := s.Webhooks("dev"); !webhooks[0].Valid {
+		t.Error("Webhook was still invalid after a passing CRC check")
+	}
+
+	if err := tt.DeleteWebhook("dev", webhook.Id); err != nil {
+		t.Fatal("Error deleting webhook:", err.Error())
+	}
+	if webhooks, err := tt.Webhooks("dev"); err != nil || len(webhooks) != 0 {
+		t.Errorf("Expected no dev webhooks after deleting, got %+v, %v", webhooks, err)
+	}
+	if err := tt.DeleteWebhook("dev", webhook.Id); !IsNotFound(err) {
+		t.Errorf("Expected a not found error deleting twice, got %v", err)
+	}
+}
+
+func TestRegisterWebhookCRC(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	broken := int32(1)
+	hook := newWebhookServer(tt, &broken)
+	defer hook.Close()
+
+	if _, err := tt.RegisterWebhook("dev", hook.URL+"/webhook"); err == nil {
+		t.Error("Webhook failing the CRC check was registered")
+	}
+	if webhooks := s.Webhooks("dev"); len(webhooks) != 0 {
+		t.Errorf("Expected no dev webhooks, got %+v", webhooks)
+	}
+}
+
+func TestEnsureWebhook(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	var broken int32
+	hook := newWebhookServer(tt, &broken)
+	defer hook.Close()
+
+	first, err := tt.EnsureWebhook("dev", hook.URL+"/webhook")
+	if err != nil {
+		t.Fatal("Error ensuring webhook:", err.Error())
+	}
+	again, err := tt.EnsureWebhook("dev", hook.URL+"/webhook")
+	if err != nil {
+		t.Fatal("Error ensuring webhook again:", err.Error())
+	}
+	if again != first {
+		t.Errorf("Expected the same webhook %+v, got %+v", first, again)
+	}
+
+	// an invalid webhook is re-validated rather than replaced
+	atomic.StoreInt32(&broken, 1)
+	tt.TriggerCRC("dev", first.Id)
+	atomic.StoreInt32(&broken, 0)
+	if again, err = tt.EnsureWebhook("dev", hook.URL+"/webhook"); err != nil {
+		t.Fatal("Error ensuring invalidated webhook:", err.Error())
+	}
+	if again.Id != first.Id || !again.Valid {
+		t.Errorf("Expected webhook %s to be valid again, got %+v", first.Id, again)
+	}
+	if webhooks := s.Webhooks("dev"); !webhooks[0].Valid {
+		t.Error("Webhook was not re-validated")
+	}
+
+	// at the free tier's limit of one, a new url replaces the old webhook
+	moved, err := tt.EnsureWebhook("dev", hook.URL+"/v2/webhook")
+	if err != nil {
+		t.Fatal("Error ensuring webhook with a new url:", err.Error())
+	}
+	if moved.Id == first.Id || moved.Url != hook.URL+"/v2/webhook" {
+		t.Errorf("Expected a new webhook for the new url, got %+v", moved)
+	}
+	if webhooks := s.Webhooks("dev"); len(webhooks) != 1 || webhooks[0].Id != moved.Id {
+		t.Errorf("Expected only webhook %s, got %+v", moved.Id, webhooks)
+	}
+}
+
+func TestEnsureWebhookKeepsOthers(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.WebhookLimit = 2
+	tt := newClient(s)
+
+	var broken int32
+	hook := newWebhookServer(tt, &broken)
+	defer hook.Close()
+
+	ensure := func(path string) Webhook {
+		webhook, err := tt.EnsureWebhook("prod", hook.URL+path)
+		if err != nil {
+			t.Fatal("Error ensuring webhook:", err.Error())
+		}
+		return webhook
+	}
+	ids := func() (ids []string) {
+		for _, w := range s.Webhooks("prod") {
+			ids = append(ids, w.Id)
+		}
+		return
+	}
+
+	// another deployment's webhook survives while there is room
+	blue := ensure("/blue")
+	green := ensure("/green")
+	if got := ids(); len(got) != 2 || got[0] != blue.Id || got[1] != green.Id {
+		t.Errorf("Expected prod webhooks [%s %s], got %v", blue.Id, green.Id, got)
+	}
+
+	// at the limit, only the oldest makes way
+	canary := ensure("/canary")
+	if got := ids(); len(got) != 2 || got[0] != green.Id || got[1] != canary.Id {
+		t.Errorf("Expected prod webhooks [%s %s], got %v", green.Id, canary.Id, got)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	if err := tt.Subscribe("dev"); err == nil {
+		t.Error("Subscribed without a webhook")
+	}
+
+	var broken int32
+	hook := newWebhookServer(tt, &broken)
+	defer hook.Close()
+	if _, err := tt.EnsureWebhook("dev", hook.URL+"/webhook"); err != nil {
+		t.Fatal("Error ensuring webhook:", err.Error())
+	}
+
+	if subscribed, err := tt.IsSubscribed("dev"); err != nil || subscribed {
+		t.Errorf("Expected no subscription before subscribing, got %v, %v", subscribed, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := tt.EnsureSubscription("dev"); err != nil {
+			t.Fatal("Error ensuring subscription:", err.Error())
+		}
+	}
+	if subscribed, err := tt.IsSubscribed("dev"); err != nil || !subscribed {
+		t.Errorf("Expected a subscription after subscribing, got %v, %v", subscribed, err)
+	}
+
+	list, err := tt.Subscriptions("dev")
+	if err != nil {
+		t.Fatal("Error listing subscriptions:", err.Error())
+	}
+	if list.Environment != "dev" || len(list.Subscriptions) != 1 || list.Subscriptions[0].UserId != "76395009" {
+		t.Errorf("Expected one dev subscription for 76395009, got %+v", list)
+	}
+
+	if err := tt.Unsubscribe("dev", "76395009"); err != nil {
+		t.Fatal("Error unsubscribing:", err.Error())
+	}
+	if subscribed, err := tt.IsSubscribed("dev"); err != nil || subscribed {
+		t.Errorf("Expected no subscription after unsubscribing, got %v, %v", subscribed, err)
+	}
+	if err := tt.Unsubscribe("dev", "76395009"); !IsNotFound(err) {
+		t.Errorf("Expected a not found error unsubscribing twice, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+// Selects whose ids to walk and how. Set either UserId or ScreenName.
+type IdsParams struct {
+	UserId     int64
+	ScreenName string
+
+	// Ids per page, at most 5000. Twitter's default is used if zero.
+	Count int
+
+	// Where to start, eg. the Cursor of an earlier walk that was
+	// interrupted. The first page is used if zero.
+	Cursor int64
+}
+
+// Walks a cursored list of user ids a page at a time:
+//
+//	it := t.FollowerIds(IdsParams{ScreenName: "bsdf"})
+//	for it.Next() {
+//		for _, id := range it.Ids() {
+//			...
+//		}
+//	}
+//	if it.Err() != nil {
+//		...
+//	}
+type IdsIterator struct {
+	t      *Twitter
+	ctx    context.Context
+	path   string
+	params IdsParams
+
+	ids    []int64
+	cursor int64
+	err    error
+}
+
+// Walks the ids of the users a user follows
+func (t *Twitter) FriendIds(params IdsParams) *IdsIterator {
+	return t.FriendIdsContext(context.Background(), params)
+}
+
+// FriendIds with a context for cancellation and deadlines
+func (t *Twitter) FriendIdsContext(ctx context.Context, params IdsParams) *IdsIterator {
+	return t.newIdsIterator(ctx, "friends/ids.json", params)
+}
+
+// Walks the ids of the users following a user
+func (t *Twitter) FollowerIds(params IdsParams) *IdsIterator {
+	return t.FollowerIdsContext(context.Background(), params)
+}
+
+// FollowerIds with a context for cancellation and deadlines
+func (t *Twitter) FollowerIdsContext(ctx context.Context, params IdsParams) *IdsIterator {
+	return t.newIdsIterator(ctx, "followers/ids.json", params)
+}
+
+func (t *Twitter) newIdsIterator(ctx context.Context, path string, params IdsParams) *IdsIterator {
+	cursor := params.Cursor
+	if cursor == 0 {
+		cursor = -1
+	}
+	return &IdsIterator{t: t, ctx: ctx, path: path, params: params, cursor: cursor}
+}
+
+// Fetches the next page, returning false once there are no more pages
+// or a request fails
+func (it *IdsIterator) Next() bool {
+	if it.err != nil || it.cursor == 0 {
+		return false
+	}
+
+	v := url.Values{}
+	if it.params.UserId != 0 {
+		v.Set("user_id", strconv.FormatInt(it.params.UserId, 10))
+	} else {
+		v.Set("screen_name", it.params.ScreenName)
+	}
+	if it.params.Count > 0 {
+		v.Set("count", strconv.Itoa(it.params.Count))
+	}
+	v.Set("cursor", strconv.FormatInt(it.cursor, 10))
+
+	method := &RestMethod{
+		Url:    it.t.apiUrl(it.path + "?" + queryString(v)),
+		Method: "GET",
+		auth:   appAuth,
+	}
+
+	body, err := it.t.sendRestRequest(it.ctx, method)
+	if err != nil {
+		it.err = err
+		return false
+	}
+
+	var page = struct {
+		Ids        []int64 `json:"ids"`
+		NextCursor int64   `json:"next_cursor"`
+	}{}
+
+	if err = json.Unmarshal(body, &page); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.ids = page.Ids
+	it.cursor = page.NextCursor
+	return true
+}
+
+// Returns the ids on the current page
+func (it *IdsIterator) Ids() []int64 {
+	return it.ids
+}
+
+// Returns the cursor of the page after the current one, which resumes
+// the walk when passed as IdsParams.Cursor. Zero after the last page.
+func (it *IdsIterator) Cursor() int64 {
+	return it.cursor
+}
+
+// Returns the error that stopped the walk, if any
+func (it *IdsIterator) Err() error {
+	return it.err
+}
+
+// Walks the remaining pages in the background, sending every id on the
+// returned channel. The channel is closed when the walk stops; check Err
+// afterwards. Cancel the iterator's context to stop early.
+func (it *IdsIterator) Chan() <-chan int64 {
+	ch := make(chan int64)
+
+	go func() {
+		defer close(ch)
+
+		for it.Next() {
+			for _, id := range it.ids {
+				select {
+				case ch <- id:
+				case <-it.ctx.Done():
+					it.err = it.ctx.Err()
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
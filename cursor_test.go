@@ -0,0 +1,120 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+// Starts a fake server where @bsdf follows 13 users and is followed
+// by 12
+func newCursorServer() (*Twitter, *twittertest.Server) {
+	s := newTestServer()
+	for id := int64(1); id <= 12; id++ {
+		s.AddFriend(14114455, id)
+		s.AddFriend(id, 14114455)
+	}
+
+	return newClient(s), s
+}
+
+func TestIdsIterator(t *testing.T) {
+	tt, s := newCursorServer()
+	defer s.Close()
+
+	it := tt.FollowerIds(IdsParams{ScreenName: "bsdf", Count: 5})
+	var pages, ids int
+	for it.Next() {
+		pages++
+		ids += len(it.Ids())
+	}
+	if it.Err() != nil {
+		t.Fatal("Error walking follower ids:", it.Err())
+	}
+
+	if pages != 3 || ids != 12 {
+		t.Errorf("Expected 12 ids over 3 pages, got %d over %d", ids, pages)
+	}
+	if it.Cursor() != 0 {
+		t.Errorf("Expected cursor 0 after the last page, got %d", it.Cursor())
+	}
+}
+
+func TestIdsIteratorResume(t *testing.T) {
+	tt, s := newCursorServer()
+	defer s.Close()
+
+	it := tt.FriendIds(IdsParams{UserId: 14114455, Count: 5})
+	if !it.Next() {
+		t.Fatal("Error walking friend ids:", it.Err())
+	}
+	first := it.Ids()
+
+	resumed := tt.FriendIds(IdsParams{UserId: 14114455, Count: 5, Cursor: it.Cursor()})
+	if !resumed.Next() {
+		t.Fatal("Error resuming friend ids:", resumed.Err())
+	}
+	if resumed.Ids()[0] == first[0] || resumed.Ids()[0] != first[len(first)-1]+1 {
+		t.Errorf("Resumed walk did not continue after %v, got %v", first, resumed.Ids())
+	}
+}
+
+func TestIdsChan(t *testing.T) {
+	tt, s := newCursorServer()
+	defer s.Close()
+
+	it := tt.FriendIds(IdsParams{ScreenName: "bsdf", Count: 5})
+	seen := map[int64]bool{}
+	for id := range it.Chan() {
+		seen[id] = true
+	}
+	if it.Err() != nil || len(seen) != 13 {
+		t.Errorf("Expected 13 friend ids, got %d (%v)", len(seen), it.Err())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it = tt.FriendIdsContext(ctx, IdsParams{ScreenName: "bsdf", Count: 5})
+	ch := it.Chan()
+	<-ch
+	cancel()
+	for range ch {
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("Expected the walk to stop with the context, got %v", it.Err())
+	}
+}
+
+func TestGetUserFriendsAllPages(t *testing.T) {
+	tt, s := newCursorServer()
+	defer s.Close()
+
+	s.InjectError("/1.1/friends/ids.json", twittertest.Error{Status: 503})
+	if _, err := tt.GetUserFriends("bsdf"); err == nil {
+		t.Error("Expected an error from a failed page")
+	}
+
+	friends, err := tt.GetUserFriends("bsdf")
+	if err != nil {
+		t.Fatal("Error retrieving friends:", err.Error())
+	}
+	if len(friends) != 13 {
+		t.Errorf("Expected 13 friends, got %d", len(friends))
+	}
+}
@@ -0,0 +1,161 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Twitter API error codes
+const (
+	ErrCodeCouldNotAuthenticate  = 32
+	ErrCodePageNotFound          = 34
+	ErrCodeUserNotFound          = 50
+	ErrCodeRateLimitExceeded     = 88
+	ErrCodeInvalidToken          = 89
+	ErrCodeBadCredentials        = 99
+	ErrCodeOverCapacity          = 130
+	ErrCodeInternalError         = 131
+	ErrCodeTimestampOutOfBounds  = 135
+	ErrCodeStatusNotFound        = 144
+	ErrCodeDuplicateStatus       = 187
+	ErrCodeTooManyResources      = 214
+	ErrCodeBadAuthenticationData = 215
+	ErrCodeCredentialsNotAllowed = 220
+)
+
+// An entry of the errors array in an API response
+type ErrorDetail struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// An error returned by the Twitter API
+type APIError struct {
+	StatusCode int
+	Errors     []ErrorDetail
+
+	// The request that failed
+	Method string
+	Url    string
+
+	// Rate limit state reported with the response, if any
+	RateLimit RateLimit
+
+	// Raw response body
+	Body string
+}
+
+func (e *APIError) Error() string {
+	var messages []string
+	for _, detail := range e.Errors {
+		messages = append(messages, fmt.Sprintf("%s (code %d)", detail.Message, detail.Code))
+	}
+	if len(messages) == 0 {
+		messages = append(messages, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Url, e.StatusCode, strings.Join(messages, "; "))
+}
+
+// Reports whether the response contained the error code
+func (e *APIError) HasCode(code int) bool {
+	for _, detail := range e.Errors {
+		if detail.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds an APIError from a failed response
+func newAPIError(m *RestMethod, resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     m.Method,
+		Url:        m.Url,
+		Body:       string(body),
+	}
+	e.RateLimit, _ = parseRateLimit(resp.Header)
+
+	// errors come as {"errors": [{"code": 34, "message": "..."}]},
+	// or for older and OAuth endpoints {"error": "..."}
+	var response = struct {
+		Errors json.RawMessage
+		Error  string
+	}{}
+
+	if json.Unmarshal(body, &response) != nil {
+		return e
+	}
+
+	if json.Unmarshal(response.Errors, &e.Errors) != nil {
+		var message string
+		if json.Unmarshal(response.Errors, &message) == nil {
+			e.Errors = []ErrorDetail{{Message: message}}
+		}
+	}
+	if response.Error != "" {
+		e.Errors = append(e.Errors, ErrorDetail{Message: response.Error})
+	}
+	return e
+}
+
+// Returns err as an *APIError if it is one
+func asAPIError(err error) (*APIError, bool) {
+	var apiErr *APIError
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+// Reports whether err is a rate limit error
+func IsRateLimited(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && (e.StatusCode == 429 || e.HasCode(ErrCodeRateLimitExceeded))
+}
+
+// Reports whether err was caused by a missing user, tweet or page
+func IsNotFound(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && (e.StatusCode == http.StatusNotFound ||
+		e.HasCode(ErrCodePageNotFound) ||
+		e.HasCode(ErrCodeUserNotFound) ||
+		e.HasCode(ErrCodeStatusNotFound))
+}
+
+// Reports whether err was caused by tweeting the same status twice
+func IsDuplicateStatus(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && e.HasCode(ErrCodeDuplicateStatus)
+}
+
+// Reports whether err was caused by missing, invalid or insufficient
+// credentials
+func IsAuthError(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && (e.StatusCode == http.StatusUnauthorized ||
+		e.HasCode(ErrCodeCouldNotAuthenticate) ||
+		e.HasCode(ErrCodeInvalidToken) ||
+		e.HasCode(ErrCodeBadCredentials) ||
+		e.HasCode(ErrCodeTimestampOutOfBounds) ||
+		e.HasCode(ErrCodeBadAuthenticationData) ||
+		e.HasCode(ErrCodeCredentialsNotAllowed))
+}
@@ -0,0 +1,132 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+func TestNotFoundError(t *testing.T) {
+	_, err := tw.GetUserTimeline("USERNAME_DONT_EXIST")
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("Expected *APIError, got %T: %v", err, err)
+		return
+	}
+
+	if apiErr.StatusCode != 404 || !apiErr.HasCode(ErrCodePageNotFound) {
+		t.Errorf("Unexpected status or code: %d %v", apiErr.StatusCode, apiErr.Errors)
+	}
+	if apiErr.Method != "GET" || apiErr.Url != tw.apiUrl("statuses/user_timeline.json?screen_name=USERNAME_DONT_EXIST") {
+		t.Errorf("Failed request was not recorded: %s %s", apiErr.Method, apiErr.Url)
+	}
+
+	if !IsNotFound(err) || IsAuthError(err) || IsRateLimited(err) {
+		t.Error("Error was not classified as not found:", err.Error())
+	}
+}
+
+func TestDuplicateStatusError(t *testing.T) {
+	status := fmt.Sprintf("said twice #%d", time.Now().UnixNano())
+	if _, err := tw.Tweet(status); err != nil {
+		t.Error("Error tweeting:", err.Error())
+		return
+	}
+
+	_, err := tw.Tweet(status)
+	if !IsDuplicateStatus(err) {
+		t.Error("Expected duplicate status error, got:", err)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:      config.ConsumerKey,
+		ConsumerSecret:   config.ConsumerSecret,
+		OAuthToken:       config.OAuthToken,
+		OAuthTokenSecret: "wrong",
+		ApiUrl:           server.URL + "/1.1",
+	}
+
+	_, err := tt.GetDirectMessages()
+	if !IsAuthError(err) || IsNotFound(err) {
+		t.Error("Expected auth error, got:", err)
+	}
+}
+
+func TestRateLimitedError(t *testing.T) {
+	reset := time.Now().Add(15 * time.Minute).Unix()
+	server.InjectError("/1.1/search/tweets.json", twittertest.Error{
+		Status:  429,
+		Code:    ErrCodeRateLimitExceeded,
+		Message: "Rate limit exceeded",
+		Header: http.Header{
+			"X-Rate-Limit-Limit":     {"180"},
+			"X-Rate-Limit-Remaining": {"0"},
+			"X-Rate-Limit-Reset":     {fmt.Sprint(reset)},
+		},
+	})
+
+	_, err := tw.Search("gucci mane")
+	if !IsRateLimited(err) {
+		t.Error("Expected rate limit error, got:", err)
+		return
+	}
+
+	apiErr := err.(*APIError)
+	if apiErr.RateLimit.Limit != 180 || apiErr.RateLimit.Remaining != 0 || apiErr.RateLimit.Reset.Unix() != reset {
+		t.Errorf("Rate limit headers were not parsed: %+v", apiErr.RateLimit)
+	}
+}
+
+func TestNonJSONError(t *testing.T) {
+	page := "<html><body>Twitter is over capacity.</body></html>"
+	server.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 503, Body: page})
+
+	_, err := tw.GetTOS()
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Errorf("Expected *APIError, got %T: %v", err, err)
+		return
+	}
+	if apiErr.StatusCode != 503 || apiErr.Body != page || len(apiErr.Errors) != 0 {
+		t.Errorf("Unexpected error for HTML response: %d %q %v", apiErr.StatusCode, apiErr.Body, apiErr.Errors)
+	}
+}
+
+func TestEmptyError(t *testing.T) {
+	server.InjectError("/1.1/help/privacy.json", twittertest.Error{Status: 401})
+
+	_, err := tw.GetPrivacyPolicy()
+	if !IsAuthError(err) {
+		t.Error("Expected auth error for empty 401, got:", err)
+		return
+	}
+
+	expected := "GET " + tw.apiUrl("help/privacy.json") + ": 401 Unauthorized"
+	if err.Error() != expected {
+		t.Errorf("Expected error %q, got %q", expected, err.Error())
+	}
+}
@@ -0,0 +1,295 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Media categories for chunked uploads. Video and GIFs must be uploaded
+// with their category to be processed for use in tweets.
+const (
+	MediaCategoryImage = "tweet_image"
+	MediaCategoryGif   = "tweet_gif"
+	MediaCategoryVideo = "tweet_video"
+)
+
+// States of processing_info
+const (
+	ProcessingPending    = "pending"
+	ProcessingInProgress = "in_progress"
+	ProcessingFailed     = "failed"
+	ProcessingSucceeded  = "succeeded"
+)
+
+// Size of each APPEND in a chunked upload when MediaParams.ChunkSize is
+// zero. Twitter accepts chunks of up to 5MB.
+const DefaultMediaChunkSize = 1 << 20
+
+// Shortest wait between STATUS checks, used when Twitter doesn't ask
+// for a longer one
+const DefaultMediaCheckInterval = time.Second
+
+// Media uploaded to upload.twitter.com, ready to attach to a tweet
+// with TweetWithMedia once processing has succeeded
+type UploadedMedia struct {
+	MediaId          int64  `json:"media_id"`
+	MediaIdString    string `json:"media_id_string"`
+	Size             int
+	ExpiresAfterSecs int             `json:"expires_after_secs"`
+	ProcessingInfo   *ProcessingInfo `json:"processing_info"`
+}
+
+// Progress of the asynchronous processing of video and GIFs
+type ProcessingInfo struct {
+	State           string
+	CheckAfterSecs  int `json:"check_after_secs"`
+	ProgressPercent int `json:"progress_percent"`
+	Error           *MediaProcessingError
+}
+
+// Returned when uploaded media fails processing
+type MediaProcessingError struct {
+	Code    int
+	Name    string
+	Message string
+}
+
+func (e *MediaProcessingError) Error() string {
+	return fmt.Sprintf("media processing failed: %s (%d): %s", e.Name, e.Code, e.Message)
+}
+
+// Options for a chunked upload
+type MediaParams struct {
+	// MIME type of the media, eg. "video/mp4". Required.
+	MediaType string
+	// One of the MediaCategory constants
+	Category string
+	// Bytes sent per APPEND. DefaultMediaChunkSize is used if zero.
+	ChunkSize int
+}
+
+// Uploads an image in a single multipart request
+func (t *Twitter) UploadMedia(media []byte) (UploadedMedia, error) {
+	return t.UploadMediaContext(context.Background(), media)
+}
+
+// UploadMedia with a context for cancellation and deadlines
+func (t *Twitter) UploadMediaContext(ctx context.Context, media []byte) (uploaded UploadedMedia, err error) {
+	method, err := t.mediaMethod(nil, media)
+	if err != nil {
+		return
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &uploaded)
+	return
+}
+
+// Uploads size bytes read from r with the chunked INIT, APPEND and
+// FINALIZE commands, as required for video and GIFs. When Twitter
+// processes the media afterwards, its STATUS is polled until processing
+// finishes. A *MediaProcessingError is returned if it fails.
+func (t *Twitter) UploadMediaChunked(r io.Reader, size int64, params MediaParams) (UploadedMedia, error) {
+	return t.UploadMediaChunkedContext(context.Background(), r, size, params)
+}
+
+// UploadMediaChunked with a context for cancellation and deadlines
+func (t *Twitter) UploadMediaChunkedContext(ctx context.Context, r io.Reader, size int64, params MediaParams) (uploaded UploadedMedia, err error) {
+	if params.MediaType == "" {
+		return uploaded, errors.New("MediaType is required for chunked uploads")
+	}
+
+	chunkSize := params.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultMediaChunkSize
+	}
+
+	v := url.Values{}
+	v.Set("command", "INIT")
+	v.Set("total_bytes", strconv.FormatInt(size, 10))
+	v.Set("media_type", params.MediaType)
+	if params.Category != "" {
+		v.Set("media_category", params.Category)
+	}
+	if uploaded, err = t.mediaCommand(ctx, v); err != nil {
+		return
+	}
+	mediaId := uploaded.MediaIdString
+
+	chunk := make([]byte, chunkSize)
+	var sent int64
+	for segment := 0; sent < size; segment++ {
+		n, err := io.ReadFull(r, chunk[:minInt64(int64(chunkSize), size-sent)])
+		if err != nil {
+			return uploaded, err
+		}
+
+		fields := map[string]string{
+			"command":       "APPEND",
+			"media_id":      mediaId,
+			"segment_index": strconv.Itoa(segment),
+		}
+		method, err := t.mediaMethod(fields, chunk[:n])
+		if err != nil {
+			return uploaded, err
+		}
+		if _, err = t.sendRestRequest(ctx, method); err != nil {
+			return uploaded, err
+		}
+		sent += int64(n)
+	}
+
+	v = url.Values{}
+	v.Set("command", "FINALIZE")
+	v.Set("media_id", mediaId)
+	if uploaded, err = t.mediaCommand(ctx, v); err != nil {
+		return
+	}
+
+	return t.awaitProcessing(ctx, uploaded)
+}
+
+// Returns the processing state of uploaded media
+func (t *Twitter) MediaStatus(mediaId int64) (UploadedMedia, error) {
+	return t.MediaStatusContext(context.Background(), mediaId)
+}
+
+// MediaStatus with a context for cancellation and deadlines
+func (t *Twitter) MediaStatusContext(ctx context.Context, mediaId int64) (uploaded UploadedMedia, err error) {
+	v := url.Values{}
+	v.Set("command", "STATUS")
+	v.Set("media_id", strconv.FormatInt(mediaId, 10))
+
+	method := &RestMethod{
+		Url:    t.uploadUrl("media/upload.json?" + queryString(v)),
+		Method: "GET",
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &uploaded)
+	return
+}
+
+// Polls STATUS, waiting as long as Twitter asks between checks, until
+// processing of uploaded has finished
+func (t *Twitter) awaitProcessing(ctx context.Context, uploaded UploadedMedia) (UploadedMedia, error) {
+	for {
+		info := uploaded.ProcessingInfo
+		switch {
+		case info == nil || info.State == ProcessingSucceeded:
+			return uploaded, nil
+		case info.State == ProcessingFailed:
+			if info.Error == nil {
+				info.Error = &MediaProcessingError{Message: "no reason given"}
+			}
+			return uploaded, info.Error
+		}
+
+		wait := time.Duration(info.CheckAfterSecs) * time.Second
+		if interval := t.mediaCheckInterval(); wait < interval {
+			wait = interval
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return uploaded, err
+		}
+
+		var err error
+		if uploaded, err = t.MediaStatusContext(ctx, uploaded.MediaId); err != nil {
+			return uploaded, err
+		}
+	}
+}
+
+func (t *Twitter) mediaCheckInterval() time.Duration {
+	if t.MediaCheckInterval > 0 {
+		return t.MediaCheckInterval
+	}
+	return DefaultMediaCheckInterval
+}
+
+// Sends a form encoded media/upload command
+func (t *Twitter) mediaCommand(ctx context.Context, v url.Values) (uploaded UploadedMedia, err error) {
+	method := &RestMethod{
+		Url:    t.uploadUrl("media/upload.json"),
+		Method: "POST",
+		Data:   queryString(v),
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &uploaded)
+	return
+}
+
+// Builds a multipart media/upload request carrying fields and media.
+// Multipart fields are not part of the OAuth signature.
+func (t *Twitter) mediaMethod(fields map[string]string, media []byte) (*RestMethod, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	for _, k := range sortMapKeys(fields) {
+		if err := w.WriteField(k, fields[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	part, err := w.CreateFormFile("media", "media")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(media); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &RestMethod{
+		Url:         t.uploadUrl("media/upload.json"),
+		Method:      "POST",
+		Data:        buf.String(),
+		ContentType: w.FormDataContentType(),
+	}, nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,204 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+// Starts a server that doesn't say how long to wait between media
+// STATUS checks, and a client for it that checks every 20ms
+func newMediaServer() (*Twitter, *twittertest.Server) {
+	s := newTestServer()
+	s.MediaCheckAfter = 0
+
+	tt := newClient(s)
+	tt.MediaCheckInterval = 20 * time.Millisecond
+	return tt, s
+}
+
+// Returns the media/upload requests sending command. APPEND is matched
+// by its multipart body, whose fields aren't recorded.
+func mediaCommands(s *twittertest.Server, method, command string) (requests []twittertest.Request) {
+	for _, r := range s.Requests() {
+		if r.Path != "/1.1/media/upload.json" || r.Method != method {
+			continue
+		}
+		multipart := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/")
+		if r.Form.Get("command") == command || r.Query.Get("command") == command ||
+			command == "APPEND" && multipart {
+			requests = append(requests, r)
+		}
+	}
+	return
+}
+
+func TestUploadMedia(t *testing.T) {
+	tt, s := newMediaServer()
+	defer s.Close()
+
+	image := []byte("\x89PNG\r\n\x1a\n not really a png")
+	uploaded, err := tt.UploadMedia(image)
+	if err != nil {
+		t.Fatal("Error uploading media:", err.Error())
+	}
+	if uploaded.MediaId == 0 || uploaded.Size != len(image) || uploaded.ProcessingInfo != nil {
+		t.Errorf("Uploaded media was not returned correctly: %+v", uploaded)
+	}
+	if data, ok := s.Media(uploaded.MediaId); !ok || !bytes.Equal(data, image) {
+		t.Errorf("Expected the server to have %q, got %q", image, data)
+	}
+
+	requests := s.Requests()
+	last := requests[len(requests)-1]
+	if ct := last.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/form-data; boundary=") {
+		t.Errorf("Expected a multipart upload, got Content-Type %q", ct)
+	}
+}
+
+func TestUploadMediaChunked(t *testing.T) {
+	tt, s := newMediaServer()
+	defer s.Close()
+
+	video := bytes.Repeat([]byte("0123456789"), 25)
+	uploaded, err := tt.UploadMediaChunked(bytes.NewReader(video), int64(len(video)), MediaParams{
+		MediaType: "video/mp4",
+		Category:  MediaCategoryVideo,
+		ChunkSize: 100,
+	})
+	if err != nil {
+		t.Fatal("Error uploading media:", err.Error())
+	}
+	if uploaded.ProcessingInfo == nil || uploaded.ProcessingInfo.State != ProcessingSucceeded {
+		t.Errorf("Expected processing to succeed, got %+v", uploaded.ProcessingInfo)
+	}
+	if data, ok := s.Media(uploaded.MediaId); !ok || !bytes.Equal(data, video) {
+		t.Errorf("Expected the server to assemble %q, got %q", video, data)
+	}
+
+	if n := len(mediaCommands(s, "POST", "APPEND")); n != 3 {
+		t.Errorf("Expected 3 APPEND requests, got %d", n)
+	}
+	status := mediaCommands(s, "GET", "STATUS")
+	if len(status) != 2 {
+		t.Fatalf("Expected 2 STATUS requests, got %d", len(status))
+	}
+
+	// each check waits at least the interval after the one before
+	checks := append(mediaCommands(s, "POST", "FINALIZE"), status...)
+	for i := 1; i < len(checks); i++ {
+		if gap := checks[i].Time.Sub(checks[i-1].Time); gap < tt.MediaCheckInterval {
+			t.Errorf("Expected at least %v between checks, got %v", tt.MediaCheckInterval, gap)
+		}
+	}
+
+	tweet, err := tt.TweetWithMedia("watch this", uploaded.MediaId)
+	if err != nil {
+		t.Fatal("Error tweeting with media:", err.Error())
+	}
+	media := tweet.Entities.Media
+	if len(media) != 1 || media[0].Id != uploaded.MediaId || media[0].Type != "video" {
+		t.Errorf("Expected the tweet to have video %d, got %+v", uploaded.MediaId, media)
+	}
+}
+
+func TestUploadMediaChunkedImage(t *testing.T) {
+	tt, s := newMediaServer()
+	defer s.Close()
+
+	image := []byte("GIF89a not really an image")
+	uploaded, err := tt.UploadMediaChunked(bytes.NewReader(image), int64(len(image)), MediaParams{
+		MediaType: "image/png",
+		Category:  MediaCategoryImage,
+	})
+	if err != nil {
+		t.Fatal("Error uploading media:", err.Error())
+	}
+	if uploaded.ProcessingInfo != nil {
+		t.Errorf("Expected the image not to be processed, got %+v", uploaded.ProcessingInfo)
+	}
+	if n := len(mediaCommands(s, "GET", "STATUS")); n != 0 {
+		t.Errorf("Expected no STATUS requests for an image, got %d", n)
+	}
+
+	if _, err := tt.UploadMediaChunked(bytes.NewReader(image), int64(len(image))+1, MediaParams{MediaType: "image/png"}); err == nil {
+		t.Error("Expected an error uploading fewer bytes than size")
+	}
+}
+
+func TestUploadMediaProcessingFailed(t *testing.T) {
+	tt, s := newMediaServer()
+	defer s.Close()
+
+	data := []byte("not a video")
+	uploaded, err := tt.UploadMediaChunked(bytes.NewReader(data), int64(len(data)), MediaParams{
+		MediaType: "application/octet-stream",
+		Category:  MediaCategoryVideo,
+	})
+	perr, ok := err.(*MediaProcessingError)
+	if !ok {
+		t.Fatalf("Expected a *MediaProcessingError, got %v", err)
+	}
+	if perr.Name != "InvalidMedia" || uploaded.ProcessingInfo.State != ProcessingFailed {
+		t.Errorf("Expected an InvalidMedia failure, got %+v, %+v", perr, uploaded.ProcessingInfo)
+	}
+
+	if _, err := tt.TweetWithMedia("broken", uploaded.MediaId); err == nil {
+		t.Error("Tweeted with media that failed processing")
+	}
+}
+
+func TestUploadMediaAbort(t *testing.T) {
+	// the default server asks for a second's wait between STATUS checks
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	data := []byte("a long video")
+	_, err := tt.UploadMediaChunkedContext(ctx, bytes.NewReader(data), int64(len(data)), MediaParams{
+		MediaType: "video/mp4",
+		Category:  MediaCategoryVideo,
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if n := len(mediaCommands(s, "POST", "FINALIZE")); n != 1 {
+		t.Errorf("Expected to abort after 1 FINALIZE request, got %d", n)
+	}
+}
+
+func TestMultipartSignature(t *testing.T) {
+	tt := newClient(server)
+	method, err := tt.mediaMethod(map[string]string{"command": "APPEND"}, []byte("a=b"))
+	if err != nil {
+		t.Fatal("Error building multipart request:", err.Error())
+	}
+
+	base := tt.generateSignatureBase(method)
+	if strings.Contains(base, "command") || strings.Contains(base, "a%3Db") {
+		t.Errorf("Multipart body was signed: %s", base)
+	}
+}
@@ -18,18 +18,18 @@ package twitter
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -46,17 +46,52 @@ type RestMethod struct {
 	Method string
 	Params map[string]string
 	Data   string
+
+	// Content-Type of Data. Bodies are form encoded when empty. Only
+	// form bodies have their parameters signed.
+	ContentType string
+
+	// how the request is authorized
+	auth authType
+	// token to sign with in place of the client's OAuthToken
+	creds *credentials
+	// extra oauth_* parameters, eg. oauth_callback
+	oauthParams map[string]string
+	// application-only bearer token the request was sent with, if any
+	bearer string
 }
 
-type TwitterError struct {
-	Error   string `json:"errors"`
-	Request string
+type authType int
+
+const (
+	// OAuth 1.0a signed with the user's access token
+	userAuth authType = iota
+	// bearer token when Twitter.AppAuth is set, user auth otherwise
+	appAuth
+	// HTTP basic auth with the consumer credentials
+	basicAuth
+	// HTTP basic auth with the OAuth 2.0 client credentials, or none
+	// for public clients
+	clientAuth
+	// application-only bearer token, whether or not AppAuth is set
+	bearerAuth
+)
+
+// An OAuth token and its secret
+type credentials struct {
+	token  string
+	secret string
 }
 
 // Generates OAuth http header
 func (t *Twitter) generateOAuthHeader(m *RestMethod) string {
 	base := t.generateSignatureBase(m)
-	sig := t.generateOAuthSignature(base)
+
+	tokenSecret := t.OAuthTokenSecret
+	if m.creds != nil {
+		tokenSecret = m.creds.secret
+	}
+	sig := signHMAC(base, t.ConsumerSecret, tokenSecret)
 
 	m.Params["oauth_signature"] = sig
 
@@ -80,14 +115,7 @@ func (t *Twitter) generateSignatureBase(m *RestMethod) (sig string) {
 
 	// create OAuth params
 	if m.Params == nil {
-		m.Params = map[string]string{
-			"oauth_consumer_key":     t.ConsumerKey,
-			"oauth_nonce":            getNonce(),
-			"oauth_signature_method": "HMAC-SHA1",
-			"oauth_timestamp":        fmt.Sprintf("%d", time.Now().Unix()),
-			"oauth_token":            t.OAuthToken,
-			"oauth_version":          "1.0",
-		}
+		m.Params = t.generateOAuthParams(m)
 	}
 
 	splitUrl := strings.Split(m.Url, "?")
@@ -101,6 +129,14 @@ func (t *Twitter) generateSignatureBase(m *RestMethod) (sig string) {
 		}
 	}
 
+	if m.Data != "" && m.ContentType == "" {
+		// form body parameters are signed along with the rest, in
+		// their sorted place
+		for k, v := range mapFromQueryString(m.Data) {
+			m.Params[k] = v
+		}
+	}
+
 	// write method and url to buffer
 	buffer.WriteString(m.Method + "&")
 	buffer.WriteString(encode(url) + "&")
@@ -108,20 +144,19 @@ func (t *Twitter) generateSignatureBase(m *RestMethod) (sig string) {
 	// sort map keys
 	sortedKeys := sortMapKeys(m.Params)
 
-	// write each parameter to buffer
+	// write each parameter to buffer. query string values arrive
+	// already encoded, oauth values do not.
 	for _, v := range sortedKeys {
-		buffer.WriteString(encode(fmt.Sprintf("%s=%s&", v, m.Params[v])))
+		value := m.Params[v]
+		if strings.HasPrefix(v, "oauth_") {
+			value = encode(value)
+		}
+		buffer.WriteString(encode(fmt.Sprintf("%s=%s&", v, value)))
 	}
 
-	if m.Data != "" {
-		// append Data to buffer
-		buffer.WriteString(encode(m.Data))
-		sig = buffer.String()
-	} else {
-		// remove trailing %26 (&)
-		sig = buffer.String()
-		sig = sig[:len(sig)-3]
-	}
+	// remove trailing %26 (&)
+	sig = buffer.String()
+	sig = sig[:len(sig)-3]
 
 	if t.DebugMode {
 		fmt.Printf("Signature Base:\n%s\n\n", sig)
@@ -131,15 +166,42 @@ func (t *Twitter) generateSignatureBase(m *RestMethod) (sig string) {
 	return
 }
 
+// Returns a fresh set of OAuth params for m
+func (t *Twitter) generateOAuthParams(m *RestMethod) map[string]string {
+	params := map[string]string{
+		"oauth_consumer_key":     t.ConsumerKey,
+		"oauth_nonce":            getNonce(),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        fmt.Sprintf("%d", time.Now().Unix()),
+		"oauth_version":          "1.0",
+	}
+
+	token := t.OAuthToken
+	if m.creds != nil {
+		token = m.creds.token
+	}
+	if token != "" {
+		params["oauth_token"] = token
+	}
+
+	for k, v := range m.oauthParams {
+		params[k] = v
+	}
+	return params
+}
+
 // Turns url-style query string into a map
 func mapFromQueryString(queryString string) (m map[string]string) {
 	m = make(map[string]string)
 	params := strings.Split(queryString, "&")
 
 	for _, param := range params {
-		splitParam := strings.Split(param, "=")
+		splitParam := strings.SplitN(param, "=", 2)
 		key := splitParam[0]
-		val := splitParam[1]
+		val := ""
+		if len(splitParam) == 2 {
+			val = splitParam[1]
+		}
 
 		m[key] = val
 	}
@@ -161,7 +223,13 @@ func sortMapKeys(m map[string]string) (keys []string) {
 // Generates an OAuth signature using signatureBase
 // and secret keys
 func (t *Twitter) generateOAuthSignature(signatureBase string) string {
-	signingKey := fmt.Sprintf("%s&%s", t.ConsumerSecret, t.OAuthTokenSecret)
+	return signHMAC(signatureBase, t.ConsumerSecret, t.OAuthTokenSecret)
+}
+
+// Signs signatureBase with HMAC-SHA1 keyed by the consumer and
+// token secrets
+func signHMAC(signatureBase, consumerSecret, tokenSecret string) string {
+	signingKey := fmt.Sprintf("%s&%s", encode(consumerSecret), encode(tokenSecret))
 	hmac := hmac.New(sha1.New, []byte(signingKey))
 
 	hmac.Write([]byte(signatureBase))
@@ -175,6 +243,13 @@ func encode(str string) string {
 	return strings.Replace(esc, "+", "%20", -1)
 }
 
+// Encodes v as a query string the same way encode does, so that it is
+// signed exactly as it is sent
+func queryString(v url.Values) string {
+	esc := strings.Replace(v.Encode(), "*", "%2A", -1)
+	return strings.Replace(esc, "+", "%20", -1)
+}
+
 // Returns a Nonce value
 func getNonce() string {
 	var bytes = make([]byte, 32)
@@ -183,37 +258,119 @@ func getNonce() string {
 	return nonceRegexp.ReplaceAllString(enc, "")
 }
 
-func (t *Twitter) sendRestRequest(m *RestMethod) (body []byte, err error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest(m.Method, m.Url, strings.NewReader(m.Data))
-	header := t.generateOAuthHeader(m)
-
-	if t.DebugMode {
-		fmt.Printf("%s %s\n\n", m.Method, m.Url)
-		fmt.Printf("OAuth Header:\n%s\n\n", header)
-		if m.Data != "" {
-			fmt.Printf("Data:\n%s\n\n", m.Data)
+// Returns the Authorization header for m
+func (t *Twitter) authorization(ctx context.Context, m *RestMethod) (header string, err error) {
+	switch {
+	case m.auth == basicAuth:
+		return t.basicAuthorization(), nil
+	case m.auth == clientAuth:
+		return t.clientAuthorization(), nil
+	case m.auth == bearerAuth:
+		token, err := t.bearerToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		m.bearer = token
+		return "Bearer " + token, nil
+	case m.creds == nil && t.hasOAuth2Token():
+		token, err := t.oauth2AccessToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	case m.auth == appAuth && t.AppAuth:
+		token, err := t.bearerToken(ctx)
+		if err != nil {
+			return "", err
 		}
+		m.bearer = token
+		return "Bearer " + token, nil
 	}
+	return t.generateOAuthHeader(m), nil
+}
 
-	req.Header.Add("Authorization", header)
+// Returns the http.Client requests are sent through. Sharing a
+// single client lets keep-alive connections be reused across calls.
+func (t *Twitter) client() *http.Client {
+	if t.HttpClient != nil {
+		return t.HttpClient
+	}
+	return http.DefaultClient
+}
 
-	if m.Method == "POST" {
-		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+// Sends m, waiting out rate limits if WaitOnRateLimit is set and
+// retrying failures allowed by the Retry policy. A cached bearer token
+// that Twitter rejects is replaced and the request sent once more.
+func (t *Twitter) sendRestRequest(ctx context.Context, m *RestMethod) (body []byte, err error) {
+	renewed, waits := false, 0
+	for attempt := 1; ; {
+		if err = t.waitForRateLimit(ctx, m); err != nil {
+			return
+		}
+
+		// sign a copy so every attempt gets a fresh nonce and timestamp
+		signed := *m
+		body, err = t.doRequest(ctx, &signed)
+		if err == nil {
+			return
+		}
+
+		// a cached bearer token that has been invalidated or expired
+		// is replaced once
+		if signed.bearer != "" && !renewed && invalidBearerToken(err) {
+			t.dropBearerToken(signed.bearer)
+			renewed = true
+			continue
+		}
+
+		if t.WaitOnRateLimit && IsRateLimited(err) && waits < maxRateLimitWaits {
+			waits++
+			if limit := t.markRateLimited(m, err); !limit.Exhausted() {
+				if err = sleep(ctx, t.staleResetPause(waits)); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		if t.Retry == nil || !t.Retry.retryable(ctx, m, attempt, err) {
+			return
+		}
+		if err = sleep(ctx, t.Retry.backoff(attempt)); err != nil {
+			return
+		}
+		attempt++
 	}
+}
 
-	resp, err := client.Do(req)
+// Makes a single attempt at sending m
+func (t *Twitter) doRequest(ctx context.Context, m *RestMethod) (body []byte, err error) {
+	req, err := t.newRequest(ctx, m)
 	if err != nil {
 		return
 	}
 
+	resp, err := t.client().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	t.recordRateLimit(m, resp.Header)
+
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return
 	}
 
 	if t.DebugMode {
-		fmt.Printf("Response:\n%s\n\n", body)
+		fmt.Printf("Response:\n%s %s\n\n", resp.Status, body)
+	}
+
+	// anything but a 2xx is an error, whatever the body holds
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = newAPIError(m, resp, body)
+		return
 	}
 
 	// sanitize json
@@ -223,66 +380,154 @@ func (t *Twitter) sendRestRequest(m *RestMethod) (body []byte, err error) {
 	body = commaRegexp.ReplaceAll(body, []byte("$1"))
 
 	if len(body) >= 8 && string(body)[:7] == `{"error` {
-		var twitterError TwitterError
-		json.Unmarshal(body, &twitterError)
-
-		err = errors.New(twitterError.Error)
+		err = newAPIError(m, resp, body)
 		return
 	}
 
 	return
 }
 
-// Non-authenticated GET request
-func getResponseBody(url string) (body []byte, err error) {
-	resp, err := http.Get(url)
+// Builds an authorized request for m
+func (t *Twitter) newRequest(ctx context.Context, m *RestMethod) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, m.Method, m.Url, strings.NewReader(m.Data))
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	body, err = ioutil.ReadAll(resp.Body)
+	header, err := t.authorization(ctx, m)
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	// sanitize json
-	// remove nulls
-	body = nullRegexp.ReplaceAll(body, nil)
-	// remove any trailing commas
-	body = commaRegexp.ReplaceAll(body, []byte("$1"))
+	if t.DebugMode {
+		fmt.Printf("%s %s\n\n", m.Method, m.Url)
+		fmt.Printf("Authorization Header:\n%s\n\n", header)
+		if m.Data != "" && m.ContentType == "" {
+			fmt.Printf("Data:\n%s\n\n", m.Data)
+		} else if m.Data != "" {
+			fmt.Printf("Data:\n%d bytes of %s\n\n", len(m.Data), m.ContentType)
+		}
+	}
 
-	return
+	if header != "" {
+		req.Header.Add("Authorization", header)
+	}
+
+	if m.ContentType != "" {
+		req.Header.Add("Content-Type", m.ContentType)
+	} else if m.Method == "POST" {
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return req, nil
 }
 
+// Requests a temporary token and stores it as the client's OAuthToken
 func (t *Twitter) requestToken() (err error) {
-	params := map[string]string{
-		"oauth_consumer_key":     t.ConsumerKey,
-		"oauth_nonce":            getNonce(),
-		"oauth_signature_method": "HMAC-SHA1",
-		"oauth_timestamp":        fmt.Sprintf("%d", time.Now().Unix()),
-		"oauth_version":          "1.0",
+	token, err := t.GetRequestToken("")
+	if err != nil {
+		return
 	}
+
+	t.OAuthToken = token.Token
+	t.OAuthTokenSecret = token.Secret
+	return
+}
+
+// A temporary token identifying an authorization attempt
+type RequestToken struct {
+	Token             string
+	Secret            string
+	CallbackConfirmed bool
+}
+
+// Credentials for a user who has authorized the application
+type AccessToken struct {
+	Token      string
+	Secret     string
+	UserId     int64
+	ScreenName string
+}
+
+// Starts the OAuth flow by obtaining a request token. After authorizing,
+// the user is sent to callback with an oauth_verifier, or shown a PIN
+// when callback is "oob".
+func (t *Twitter) GetRequestToken(callback string) (token RequestToken, err error) {
+	return t.GetRequestTokenContext(context.Background(), callback)
+}
+
+// GetRequestToken with a context for cancellation and deadlines
+func (t *Twitter) GetRequestTokenContext(ctx context.Context, callback string) (token RequestToken, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/oauth/request_token",
+		Url:    t.oauthUrl("oauth/request_token"),
 		Method: "POST",
-		Params: params,
+		creds:  &credentials{},
+	}
+	if callback != "" {
+		method.oauthParams = map[string]string{"oauth_callback": callback}
 	}
 
-	body, err := t.sendRestRequest(method)
+	values, err := t.sendTokenRequest(ctx, method)
 	if err != nil {
 		return
 	}
 
-	strBody := string(body)
-	if strBody[:6] == "Failed" {
-		err = errors.New(strBody)
+	token.Token = values.Get("oauth_token")
+	token.Secret = values.Get("oauth_token_secret")
+	token.CallbackConfirmed = values.Get("oauth_callback_confirmed") == "true"
+	return
+}
+
+// Returns the url where the user grants the application access
+func (t *Twitter) AuthorizeUrl(token RequestToken) string {
+	return t.oauthUrl("oauth/authorize?oauth_token=" + encode(token.Token))
+}
+
+// Returns the "Sign in with Twitter" url, which skips the authorization
+// page for users who have already authorized the application
+func (t *Twitter) AuthenticateUrl(token RequestToken) string {
+	return t.oauthUrl("oauth/authenticate?oauth_token=" + encode(token.Token))
+}
+
+// Exchanges an authorized request token and the oauth_verifier sent to
+// the callback (or the PIN shown to the user) for access credentials
+func (t *Twitter) GetAccessToken(token RequestToken, verifier string) (access AccessToken, err error) {
+	return t.GetAccessTokenContext(context.Background(), token, verifier)
+}
+
+// GetAccessToken with a context for cancellation and deadlines
+func (t *Twitter) GetAccessTokenContext(ctx context.Context, token RequestToken, verifier string) (access AccessToken, err error) {
+	method := &RestMethod{
+		Url:         t.oauthUrl("oauth/access_token"),
+		Method:      "POST",
+		creds:       &credentials{token.Token, token.Secret},
+		oauthParams: map[string]string{"oauth_verifier": verifier},
+	}
+
+	values, err := t.sendTokenRequest(ctx, method)
+	if err != nil {
 		return
 	}
 
-	m := mapFromQueryString(strBody)
+	access.Token = values.Get("oauth_token")
+	access.Secret = values.Get("oauth_token_secret")
+	access.ScreenName = values.Get("screen_name")
+	if id := values.Get("user_id"); id != "" {
+		access.UserId, err = strconv.ParseInt(id, 10, 64)
+	}
+	return
+}
 
-	t.OAuthToken = m["oauth_token"]
-	t.OAuthTokenSecret = m["oauth_token_secret"]
+// Sends a request to one of the oauth/*_token endpoints and parses the
+// form encoded response
+func (t *Twitter) sendTokenRequest(ctx context.Context, m *RestMethod) (values url.Values, err error) {
+	body, err := t.sendRestRequest(ctx, m)
+	if err != nil {
+		return
+	}
 
+	values, err = url.ParseQuery(string(body))
+	if err != nil || values.Get("oauth_token") == "" {
+		err = fmt.Errorf("unexpected token response: %s", body)
+	}
 	return
 }
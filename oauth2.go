@@ -0,0 +1,342 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Requests an application-only bearer token using the consumer
+// credentials and caches it in BearerToken
+func (t *Twitter) GetBearerToken() (token string, err error) {
+	return t.GetBearerTokenContext(context.Background())
+}
+
+// GetBearerToken with a context for cancellation and deadlines
+func (t *Twitter) GetBearerTokenContext(ctx context.Context) (token string, err error) {
+	t.bearerMu.Lock()
+	defer t.bearerMu.Unlock()
+
+	return t.fetchBearerToken(ctx)
+}
+
+// Revokes the cached bearer token. A new one is fetched the next time
+// an application-only request is made.
+func (t *Twitter) InvalidateBearerToken() error {
+	return t.InvalidateBearerTokenContext(context.Background())
+}
+
+// InvalidateBearerToken with a context for cancellation and deadlines
+func (t *Twitter) InvalidateBearerTokenContext(ctx context.Context) (err error) {
+	t.bearerMu.Lock()
+	defer t.bearerMu.Unlock()
+
+	if t.BearerToken == "" {
+		return errors.New("no bearer token to invalidate")
+	}
+
+	method := &RestMethod{
+		Url:    t.oauthUrl("oauth2/invalidate_token"),
+		Method: "POST",
+		Data:   "access_token=" + encode(t.BearerToken),
+		auth:   basicAuth,
+	}
+
+	if _, err = t.sendRestRequest(ctx, method); err != nil {
+		return
+	}
+
+	t.BearerToken = ""
+	return
+}
+
+// Returns the cached bearer token, fetching one if needed
+func (t *Twitter) bearerToken(ctx context.Context) (token string, err error) {
+	t.bearerMu.Lock()
+	defer t.bearerMu.Unlock()
+
+	if t.BearerToken != "" {
+		return t.BearerToken, nil
+	}
+	return t.fetchBearerToken(ctx)
+}
+
+// Forgets token if it is still the cached bearer token, so that the
+// next request fetches a new one
+func (t *Twitter) dropBearerToken(token string) {
+	t.bearerMu.Lock()
+	defer t.bearerMu.Unlock()
+
+	if t.BearerToken == token {
+		t.BearerToken = ""
+	}
+}
+
+// Reports whether err rejected the bearer token a request was sent with
+func invalidBearerToken(err error) bool {
+	e, ok := asAPIError(err)
+	return ok && (e.StatusCode == http.StatusUnauthorized || e.HasCode(ErrCodeInvalidToken))
+}
+
+func (t *Twitter) currentBearerToken() string {
+	t.bearerMu.Lock()
+	defer t.bearerMu.Unlock()
+
+	return t.BearerToken
+}
+
+// Requests a bearer token from oauth2/token. bearerMu must be held.
+func (t *Twitter) fetchBearerToken(ctx context.Context) (token string, err error) {
+	method := &RestMethod{
+		Url:    t.oauthUrl("oauth2/token"),
+		Method: "POST",
+		Data:   "grant_type=client_credentials",
+		auth:   basicAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	var result = struct {
+		TokenType   string `json:"token_type"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return
+	}
+
+	if result.TokenType != "bearer" || result.AccessToken == "" {
+		return "", errors.New("unexpected bearer token response: " + string(body))
+	}
+
+	t.BearerToken = result.AccessToken
+	return t.BearerToken, nil
+}
+
+// Returns a basic Authorization header carrying the consumer
+// credentials, as used by the oauth2/* endpoints
+func (t *Twitter) basicAuthorization() string {
+	credentials := encode(t.ConsumerKey) + ":" + encode(t.ConsumerSecret)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
+
+// An OAuth 2.0 user access token
+type OAuth2Token struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	TokenType    string    `json:"token_type"`
+	Scope        string    `json:"scope"`
+	Expiry       time.Time `json:"expiry"`
+}
+
+// Reports whether the token has expired, or will within a few seconds.
+// Tokens without an expiry never expire.
+func (o OAuth2Token) Expired() bool {
+	return !o.Expiry.IsZero() && time.Now().Add(10*time.Second).After(o.Expiry)
+}
+
+// A Proof Key for Code Exchange (RFC 7636) verifier and challenge
+type PKCE struct {
+	Verifier        string
+	Challenge       string
+	ChallengeMethod string
+}
+
+// Generates a random code verifier and its S256 challenge. Use a new
+// one for every authorization request.
+func NewPKCE() (pkce PKCE, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+
+	pkce.Verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(pkce.Verifier))
+	pkce.Challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	pkce.ChallengeMethod = "S256"
+	return
+}
+
+// Returns the url where the user grants the client access to scopes.
+// After approving they are sent to redirectUrl with a code and state.
+func (t *Twitter) AuthorizeCodeUrl(redirectUrl, state string, scopes []string, pkce PKCE) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", t.ClientId)
+	v.Set("redirect_uri", redirectUrl)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", state)
+	v.Set("code_challenge", pkce.Challenge)
+	v.Set("code_challenge_method", pkce.ChallengeMethod)
+
+	authorizeUrl := t.OAuth2AuthorizeUrl
+	if authorizeUrl == "" {
+		authorizeUrl = DefaultOAuth2AuthorizeUrl
+	}
+	return authorizeUrl + "?" + queryString(v)
+}
+
+// Exchanges the code sent to redirectUrl for a user token, which is
+// stored in OAuth2Token
+func (t *Twitter) ExchangeCode(code, redirectUrl string, pkce PKCE) (token OAuth2Token, err error) {
+	return t.ExchangeCodeContext(context.Background(), code, redirectUrl, pkce)
+}
+
+// ExchangeCode with a context for cancellation and deadlines
+func (t *Twitter) ExchangeCodeContext(ctx context.Context, code, redirectUrl string, pkce PKCE) (token OAuth2Token, err error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectUrl)
+	v.Set("code_verifier", pkce.Verifier)
+
+	t.oauth2Mu.Lock()
+	defer t.oauth2Mu.Unlock()
+
+	return t.requestOAuth2Token(ctx, v)
+}
+
+// Exchanges the refresh token for a new user token. This happens
+// automatically when a request is made with an expired token.
+func (t *Twitter) RefreshOAuth2Token() (token OAuth2Token, err error) {
+	return t.RefreshOAuth2TokenContext(context.Background())
+}
+
+// RefreshOAuth2Token with a context for cancellation and deadlines
+func (t *Twitter) RefreshOAuth2TokenContext(ctx context.Context) (token OAuth2Token, err error) {
+	t.oauth2Mu.Lock()
+	defer t.oauth2Mu.Unlock()
+
+	return t.refreshOAuth2Token(ctx)
+}
+
+func (t *Twitter) hasOAuth2Token() bool {
+	t.oauth2Mu.Lock()
+	defer t.oauth2Mu.Unlock()
+
+	return t.OAuth2Token != nil
+}
+
+// Returns the current user access token, refreshing it if expired
+func (t *Twitter) oauth2AccessToken(ctx context.Context) (string, error) {
+	t.oauth2Mu.Lock()
+
+	if !t.OAuth2Token.Expired() || t.OAuth2Token.RefreshToken == "" {
+		defer t.oauth2Mu.Unlock()
+		return t.OAuth2Token.AccessToken, nil
+	}
+
+	token, err := t.refreshOAuth2Token(ctx)
+	t.oauth2Mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if t.OnTokenRefresh != nil {
+		t.OnTokenRefresh(token)
+	}
+	return token.AccessToken, nil
+}
+
+// oauth2Mu must be held
+func (t *Twitter) refreshOAuth2Token(ctx context.Context) (token OAuth2Token, err error) {
+	if t.OAuth2Token == nil || t.OAuth2Token.RefreshToken == "" {
+		err = errors.New("no refresh token")
+		return
+	}
+
+	refreshToken := t.OAuth2Token.RefreshToken
+
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("refresh_token", refreshToken)
+
+	token, err = t.requestOAuth2Token(ctx, v)
+	if err == nil && token.RefreshToken == "" {
+		// keep using the old refresh token if a new one wasn't issued
+		token.RefreshToken = refreshToken
+		t.OAuth2Token.RefreshToken = refreshToken
+	}
+	return
+}
+
+// Requests a user token from the OAuth 2.0 token endpoint and stores
+// it in OAuth2Token. oauth2Mu must be held.
+func (t *Twitter) requestOAuth2Token(ctx context.Context, v url.Values) (token OAuth2Token, err error) {
+	if t.ClientSecret == "" {
+		// public clients identify themselves in the body
+		v.Set("client_id", t.ClientId)
+	}
+
+	method := &RestMethod{
+		Url:    t.oauthUrl("2/oauth2/token"),
+		Method: "POST",
+		Data:   v.Encode(),
+		auth:   clientAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	var result = struct {
+		OAuth2Token
+		ExpiresIn int64 `json:"expires_in"`
+	}{}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return
+	}
+
+	if result.AccessToken == "" {
+		err = errors.New("unexpected token response: " + string(body))
+		return
+	}
+
+	token = result.OAuth2Token
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	t.OAuth2Token = &token
+	return
+}
+
+// Returns a basic Authorization header carrying the OAuth 2.0 client
+// credentials, or nothing for public clients
+func (t *Twitter) clientAuthorization() string {
+	if t.ClientSecret == "" {
+		return ""
+	}
+
+	credentials := encode(t.ClientId) + ":" + encode(t.ClientSecret)
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+}
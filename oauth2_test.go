@@ -0,0 +1,239 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+// Returns the Authorization header of the last request the server saw
+func lastAuthorization() string {
+	requests := server.Requests()
+	return requests[len(requests)-1].Header.Get("Authorization")
+}
+
+func TestAppAuth(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:    config.ConsumerKey,
+		ConsumerSecret: config.ConsumerSecret,
+		ApiUrl:         server.URL + "/1.1",
+		OAuthUrl:       server.URL,
+		AppAuth:        true,
+	}
+
+	tweets, err := tt.Search("gucci mane")
+	if err != nil {
+		t.Error("Error searching with app auth:", err.Error())
+		return
+	}
+	if len(tweets) == 0 {
+		t.Error("No results returned.")
+		return
+	}
+
+	if tt.BearerToken == "" {
+		t.Error("Bearer token was not cached")
+		return
+	}
+	if lastAuthorization() != "Bearer "+tt.BearerToken {
+		t.Error("Search was not sent with the bearer token:", lastAuthorization())
+		return
+	}
+
+	// user context endpoints are still signed
+	tt.OAuthToken = config.OAuthToken
+	tt.OAuthTokenSecret = config.OAuthTokenSecret
+	if _, err = tt.GetDirectMessages(); err != nil {
+		t.Error("Error retrieving DMs with user auth:", err.Error())
+		return
+	}
+	if !strings.HasPrefix(lastAuthorization(), "OAuth ") {
+		t.Error("User context request was not signed with OAuth")
+	}
+}
+
+func TestInvalidateBearerToken(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:    config.ConsumerKey,
+		ConsumerSecret: config.ConsumerSecret,
+		ApiUrl:         server.URL + "/1.1",
+		OAuthUrl:       server.URL,
+		AppAuth:        true,
+	}
+
+	token, err := tt.GetBearerToken()
+	if err != nil {
+		t.Error("Error requesting bearer token:", err.Error())
+		return
+	}
+
+	if err = tt.InvalidateBearerToken(); err != nil {
+		t.Error("Error invalidating bearer token:", err.Error())
+		return
+	}
+	if tt.BearerToken != "" {
+		t.Error("Invalidated bearer token is still cached")
+		return
+	}
+
+	// a fresh token is fetched on the next request
+	if _, err = tt.GetTOS(); err != nil {
+		t.Error("Error retrieving TOS after invalidating token:", err.Error())
+		return
+	}
+	if tt.BearerToken == "" || tt.BearerToken == token {
+		t.Error("A new bearer token was not fetched")
+	}
+
+	// a token Twitter rejects is dropped and replaced
+	tt.BearerToken = token
+	if _, err = tt.GetTOS(); err != nil {
+		t.Error("Error retrieving TOS with an invalidated token cached:", err.Error())
+		return
+	}
+	if tt.BearerToken == token || tt.BearerToken == "" {
+		t.Error("Rejected bearer token is still cached")
+	}
+}
+
+func TestRejectedBearerToken(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+	tt.AppAuth = true
+	tt.BearerToken = "revoked"
+
+	countTOS := func() (n int) {
+		for _, r := range s.Requests() {
+			if r.Path == "/1.1/help/tos.json" {
+				n++
+			}
+		}
+		return
+	}
+
+	// the replacement is only fetched once, however often it is rejected
+	s.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 401, Code: 89, Message: "Invalid or expired token."})
+	s.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 401, Code: 89, Message: "Invalid or expired token."})
+	if _, err := tt.GetTOS(); !IsAuthError(err) {
+		t.Errorf("got %v, want an auth error", err)
+	}
+	if n := countTOS(); n != 2 {
+		t.Errorf("sent %d requests, want 2", n)
+	}
+	if tt.BearerToken == "revoked" || tt.BearerToken == "" {
+		t.Errorf("cached bearer token is %q", tt.BearerToken)
+	}
+
+	if _, err := tt.GetTOS(); err != nil {
+		t.Error("Error retrieving TOS with the replacement token:", err.Error())
+	}
+}
+
+func TestBadConsumerCredentials(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:    config.ConsumerKey,
+		ConsumerSecret: "wrong",
+		OAuthUrl:       server.URL,
+	}
+
+	if _, err := tt.GetBearerToken(); err == nil {
+		t.Error("Bearer token was issued for bad consumer credentials")
+	}
+}
+
+func TestOAuth2PKCE(t *testing.T) {
+	var tt = Twitter{
+		ClientId: server.ClientId,
+		ApiUrl:   server.URL + "/1.1",
+		OAuthUrl: server.URL,
+	}
+
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Error("Error generating PKCE verifier:", err.Error())
+		return
+	}
+
+	redirect := "https://example.com/callback"
+	authorizeUrl := tt.AuthorizeCodeUrl(redirect, "state", []string{"tweet.read", "offline.access"}, pkce)
+	if !strings.HasPrefix(authorizeUrl, DefaultOAuth2AuthorizeUrl+"?") {
+		t.Error("Unexpected authorize url:", authorizeUrl)
+		return
+	}
+
+	tt.OAuth2AuthorizeUrl = server.URL + "/i/oauth2/authorize"
+	authorizeUrl = tt.AuthorizeCodeUrl(redirect, "state", []string{"tweet.read", "offline.access"}, pkce)
+	if !strings.HasPrefix(authorizeUrl, server.URL+"/i/oauth2/authorize?") {
+		t.Error("OAuth2AuthorizeUrl was not used:", authorizeUrl)
+		return
+	}
+
+	code, err := server.AuthorizeCode(authorizeUrl, 14114455)
+	if err != nil {
+		t.Error("Error authorizing code:", err.Error())
+		return
+	}
+
+	wrong, _ := NewPKCE()
+	if _, err = tt.ExchangeCode(code, redirect, wrong); err == nil {
+		t.Error("Code was exchanged with the wrong verifier")
+		return
+	}
+
+	code, _ = server.AuthorizeCode(authorizeUrl, 14114455)
+	token, err := tt.ExchangeCode(code, redirect, pkce)
+	if err != nil {
+		t.Error("Error exchanging code:", err.Error())
+		return
+	}
+	if tt.OAuth2Token == nil || tt.OAuth2Token.AccessToken != token.AccessToken || token.RefreshToken == "" {
+		t.Error("Token was not stored")
+		return
+	}
+
+	if _, err = tt.GetUser("bsdf"); err != nil {
+		t.Error("Error retrieving user with OAuth 2.0 token:", err.Error())
+		return
+	}
+	if lastAuthorization() != "Bearer "+token.AccessToken {
+		t.Error("Request was not sent with the OAuth 2.0 token:", lastAuthorization())
+		return
+	}
+
+	var refreshed OAuth2Token
+	tt.OnTokenRefresh = func(token OAuth2Token) {
+		refreshed = token
+	}
+	tt.OAuth2Token.Expiry = time.Now().Add(-time.Minute)
+
+	if _, err = tt.Tweet("sent with a refreshed token"); err != nil {
+		t.Error("Error tweeting after token expired:", err.Error())
+		return
+	}
+	if refreshed.AccessToken == "" || refreshed.AccessToken == token.AccessToken {
+		t.Error("Expired token was not refreshed")
+		return
+	}
+	if lastAuthorization() != "Bearer "+refreshed.AccessToken {
+		t.Error("Request was not sent with the refreshed token:", lastAuthorization())
+	}
+}
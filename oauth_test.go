@@ -17,7 +17,15 @@
 package twitter
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 )
@@ -96,6 +104,83 @@ func TestOAuthHeader(t *testing.T) {
 	}
 }
 
+func TestSignedFormPost(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	// display_coordinates sorts ahead of the oauth_* parameters, so the
+	// server only accepts the signature if form parameters are signed
+	// in their sorted place rather than appended
+	method := &RestMethod{
+		Url:    tt.apiUrl("statuses/update.json"),
+		Method: "POST",
+		Data:   "display_coordinates=false&status=" + encode("signed & sorted"),
+	}
+
+	body, err := tt.sendRestRequest(context.Background(), method)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tweet Tweet
+	if err = json.Unmarshal(body, &tweet); err != nil || tweet.Text != "signed & sorted" {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestHttpClient(t *testing.T) {
+	var requests []*http.Request
+	client := &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			requests = append(requests, r)
+			body := `{"tos":"Terms"}`
+			if strings.HasSuffix(r.URL.Path, "/request_token") {
+				body = "oauth_token=abc&oauth_token_secret=def"
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{},
+				Body:       ioutil.NopCloser(strings.NewReader(body)),
+			}, nil
+		}),
+	}
+
+	tt := NewWithClient("key", "secret", "token", "tokensecret", client)
+
+	tos, err := tt.GetTOS()
+	if err != nil {
+		t.Error("Error sending request through client:", err.Error())
+		return
+	}
+	if tos != "Terms" {
+		t.Error("Response from client was not returned")
+		return
+	}
+
+	if err = tt.requestToken(); err != nil {
+		t.Error("Error requesting token through client:", err.Error())
+		return
+	}
+	if tt.OAuthToken != "abc" || tt.OAuthTokenSecret != "def" {
+		t.Error("Request token was not read from client response")
+	}
+
+	if len(requests) != 2 {
+		t.Errorf("Expected 2 requests through client, got %d", len(requests))
+		return
+	}
+	if requests[0].Header.Get("Authorization") == "" {
+		t.Error("Request sent through client was not signed")
+	}
+}
+
 func TestTweet(t *testing.T) {
 	str := fmt.Sprintf("𝕙𝕖𝕝𝕝𝕠 𝕎𝕠𝕣𝕝𝕕 #%d", time.Now().Unix())
 	tweet, err := tw.Tweet(str)
@@ -116,6 +201,7 @@ func TestRequestToken(t *testing.T) {
 	var tt = Twitter{
 		ConsumerKey:    config.ConsumerKey,
 		ConsumerSecret: config.ConsumerSecret,
+		OAuthUrl:       server.URL,
 	}
 
 	err := tt.requestToken()
@@ -131,6 +217,121 @@ func TestRequestToken(t *testing.T) {
 	}
 }
 
+func TestAccessToken(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:    config.ConsumerKey,
+		ConsumerSecret: config.ConsumerSecret,
+		ApiUrl:         server.URL + "/1.1",
+		OAuthUrl:       server.URL,
+	}
+
+	token, err := tt.GetRequestToken("https://example.com/callback?from=twitter")
+	if err != nil {
+		t.Error("Error requesting token:", err.Error())
+		return
+	}
+	if !token.CallbackConfirmed {
+		t.Error("Request token succeeded, but callback was not confirmed")
+		return
+	}
+
+	authorizeUrl := server.URL + "/oauth/authorize?oauth_token=" + token.Token
+	if tt.AuthorizeUrl(token) != authorizeUrl {
+		t.Errorf("Expected authorize url %s, got %s", authorizeUrl, tt.AuthorizeUrl(token))
+	}
+
+	verifier, err := server.Authorize(token.Token, 14114455)
+	if err != nil {
+		t.Error("Error authorizing request token:", err.Error())
+		return
+	}
+
+	access, err := tt.GetAccessToken(token, verifier)
+	if err != nil {
+		t.Error("Error exchanging verifier:", err.Error())
+		return
+	}
+	if access.UserId != 14114455 || access.ScreenName != "bsdf" {
+		t.Errorf("Access token returned for wrong user: %d %s", access.UserId, access.ScreenName)
+		return
+	}
+
+	tt.OAuthToken = access.Token
+	tt.OAuthTokenSecret = access.Secret
+	if _, err = tt.Tweet("signed with a new access token"); err != nil {
+		t.Error("Error tweeting with new access token:", err.Error())
+	}
+
+	if _, err = tt.GetAccessToken(token, verifier); err == nil {
+		t.Error("Request token was exchanged twice")
+	}
+}
+
+func TestAuthorizeWithPIN(t *testing.T) {
+	var tt = Twitter{
+		ConsumerKey:    config.ConsumerKey,
+		ConsumerSecret: config.ConsumerSecret,
+		ApiUrl:         server.URL + "/1.1",
+		OAuthUrl:       server.URL,
+	}
+
+	var out bytes.Buffer
+	prompt := func(authorizeUrl string) (string, error) {
+		u, err := url.Parse(authorizeUrl)
+		if err != nil {
+			return "", err
+		}
+
+		// the user approves and types in the PIN
+		pin, err := server.Authorize(u.Query().Get("oauth_token"), 14114455)
+		if err != nil {
+			return "", err
+		}
+		return TerminalPrompt(strings.NewReader(pin+"\n"), &out)(authorizeUrl)
+	}
+
+	user, err := tt.AuthorizeWithPIN(prompt)
+	if err != nil {
+		t.Error("Error authorizing with PIN:", err.Error())
+		return
+	}
+
+	if !strings.Contains(out.String(), server.URL+"/oauth/authorize?oauth_token=") {
+		t.Error("Authorize url was not printed:", out.String())
+	}
+
+	if user.OAuthToken == "" || user.ApiUrl != tt.ApiUrl {
+		t.Error("Authorized client was not configured correctly")
+		return
+	}
+	if _, err = user.Tweet("authorized with a PIN"); err != nil {
+		t.Error("Error tweeting as authorized user:", err.Error())
+	}
+}
+
+func TestBrowserPromptWithoutBrowser(t *testing.T) {
+	defer func(open func(string) error) { openBrowser = open }(openBrowser)
+	openBrowser = func(string) error { return errors.New("no display") }
+
+	var out bytes.Buffer
+	authorizeUrl := "https://api.twitter.com/oauth/authorize?oauth_token=abc"
+	pin, err := BrowserPrompt(strings.NewReader("1234\n"), &out)(authorizeUrl)
+	if err != nil {
+		t.Fatal("Error prompting for PIN:", err.Error())
+	}
+	if pin != "1234\n" {
+		t.Errorf("Expected PIN %q, got %q", "1234\n", pin)
+	}
+
+	printed := out.String()
+	if !strings.Contains(printed, "no display") {
+		t.Error("Browser error was not printed:", printed)
+	}
+	if !strings.Contains(printed, authorizeUrl) {
+		t.Error("Authorize url was not printed:", printed)
+	}
+}
+
 func TestFollow(t *testing.T) {
 	userName := "bsdf"
 	user, err := tw.Follow(userName)
@@ -198,18 +399,42 @@ func TestSearch(t *testing.T) {
 	}
 }
 
-// func TestRateLimitStatus(t *testing.T) {
-// 	status, err := tw.GetRateLimitStatus()
-// 	if err != nil {
-// 		t.Error("Error retrieving rate limit status:", err.Error())
-// 		return
-// 	}
+func TestRateLimitStatus(t *testing.T) {
+	status, err := tw.GetRateLimitStatus()
+	if err != nil {
+		t.Error("Error retrieving rate limit status:", err.Error())
+		return
+	}
+
+	limit, ok := status.Resources["statuses"]["/statuses/user_timeline"]
+	if !ok || limit.Limit == 0 || limit.Reset.IsZero() {
+		t.Error("Rate limit status returned ok, but was not unmarshalled correctly")
+		return
+	}
+	if status.Context.AccessToken != tw.OAuthToken {
+		t.Error("Rate limit context was not returned")
+		return
+	}
+}
 
-// 	if status.ResetTime == "" {
-// 		t.Error("Rate limit status returned ok, but was not unmarshalled correctly")
-// 		return
-// 	}
-// }
+func TestRateLimitStatusFamilies(t *testing.T) {
+	status, err := tw.GetRateLimitStatus("search", "help")
+	if err != nil {
+		t.Error("Error retrieving rate limit status:", err.Error())
+		return
+	}
+
+	if len(status.Resources) != 2 || status.Resources["search"] == nil || status.Resources["help"] == nil {
+		t.Errorf("Expected only search and help families, got %v", status.Resources)
+		return
+	}
+
+	// the status also updates the tracked limits
+	limit, ok := tw.RateLimit("/help/privacy")
+	if !ok || limit != status.Resources["help"]["/help/privacy"] {
+		t.Error("Tracked rate limits were not updated from status")
+	}
+}
 
 func TestGetPrivacyPolicy(t *testing.T) {
 	policy, err := tw.GetPrivacyPolicy()
@@ -311,3 +536,22 @@ func TestGetUser(t *testing.T) {
 		return
 	}
 }
+
+func TestGetUserEscapesScreenName(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	userName := "no one&count=1"
+	if _, err := tt.GetUser(userName); err == nil {
+		t.Error("Expected an error for an unknown user")
+	}
+
+	requests := s.Requests()
+	if len(requests) == 0 {
+		t.Fatal("Expected a request to users/show")
+	}
+	if got := requests[len(requests)-1].Query.Get("screen_name"); got != userName {
+		t.Errorf("Expected screen_name %q, got %q", userName, got)
+	}
+}
@@ -0,0 +1,105 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Sends the user to authorizeUrl and returns the PIN Twitter shows
+// them once they have granted access
+type PINPrompt func(authorizeUrl string) (pin string, err error)
+
+// Authorizes a user with the out-of-band PIN flow, for tools that can't
+// receive a callback. Returns a client acting on the user's behalf.
+func (t *Twitter) AuthorizeWithPIN(prompt PINPrompt) (*Twitter, error) {
+	return t.AuthorizeWithPINContext(context.Background(), prompt)
+}
+
+// AuthorizeWithPIN with a context for cancellation and deadlines
+func (t *Twitter) AuthorizeWithPINContext(ctx context.Context, prompt PINPrompt) (*Twitter, error) {
+	token, err := t.GetRequestTokenContext(ctx, "oob")
+	if err != nil {
+		return nil, err
+	}
+
+	pin, err := prompt(t.AuthorizeUrl(token))
+	if err != nil {
+		return nil, err
+	}
+
+	pin = strings.TrimSpace(pin)
+	if pin == "" {
+		return nil, errors.New("no PIN entered")
+	}
+
+	access, err := t.GetAccessTokenContext(ctx, token, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.WithToken(access.Token, access.Secret), nil
+}
+
+// Returns a PINPrompt that prints the authorize url to out and reads
+// the PIN from a line of in
+func TerminalPrompt(in io.Reader, out io.Writer) PINPrompt {
+	return func(authorizeUrl string) (pin string, err error) {
+		fmt.Fprintf(out, "Visit this url to authorize the application:\n\n  %s\n\nEnter PIN: ", authorizeUrl)
+
+		pin, err = bufio.NewReader(in).ReadString('\n')
+		if err == io.EOF && pin != "" {
+			err = nil
+		}
+		return
+	}
+}
+
+// Like TerminalPrompt, but also tries to open the authorize url in the
+// default browser. If that fails the url is still printed to visit by
+// hand.
+func BrowserPrompt(in io.Reader, out io.Writer) PINPrompt {
+	prompt := TerminalPrompt(in, out)
+	return func(authorizeUrl string) (string, error) {
+		if err := openBrowser(authorizeUrl); err != nil {
+			fmt.Fprintf(out, "Couldn't open a browser: %s\n\n", err)
+		}
+		return prompt(authorizeUrl)
+	}
+}
+
+// Opens url with the platform's default handler. A variable so tests
+// don't launch a browser.
+var openBrowser = func(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
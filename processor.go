@@ -0,0 +1,168 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// What a Processor does when a worker's queue is full
+type BackpressurePolicy int
+
+const (
+	// Wait for room, slowing the stream down. Twitter disconnects
+	// streams that fall too far behind.
+	Block BackpressurePolicy = iota
+	// Discard the oldest queued tweet to make room
+	DropOldest
+	// Discard the tweet that didn't fit
+	DropNewest
+)
+
+// Configures a Processor
+type ProcessorConfig struct {
+	// Number of workers, runtime.NumCPU() if zero
+	Workers int
+
+	// Tweets queued per worker, 100 if zero
+	QueueSize int
+
+	// What to do when a worker's queue is full
+	Policy BackpressurePolicy
+
+	// Called with every message that isn't a tweet, such as limit
+	// notices, stall warnings, stalls and reconnects, in the order they
+	// arrive. It runs on the dispatching goroutine, so it holds up
+	// tweets until it returns. Those messages are discarded if nil.
+	OnMessage func(StreamMessage)
+}
+
+// Hands a stream's tweets to a pool of workers. Tweets are partitioned
+// by user id, so each user's tweets are handled in order by one worker.
+type Processor struct {
+	stream    *Stream
+	policy    BackpressurePolicy
+	queues    []chan Tweet
+	onMessage func(StreamMessage)
+	wg        sync.WaitGroup
+
+	processed uint64
+	dropped   uint64
+}
+
+// Starts handling the stream's tweets with a pool of workers calling
+// handle. Other messages go to config.OnMessage. The stream's Messages
+// and Tweets channels should not be read as well.
+func (s *Stream) Process(config ProcessorConfig, handle func(Tweet)) *Processor {
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+
+	p := &Processor{
+		stream:    s,
+		policy:    config.Policy,
+		queues:    make([]chan Tweet, config.Workers),
+		onMessage: config.OnMessage,
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan Tweet, config.QueueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i], handle)
+	}
+
+	go p.dispatch()
+	return p
+}
+
+// Returns the number of tweets handled so far
+func (p *Processor) Processed() uint64 {
+	return atomic.LoadUint64(&p.processed)
+}
+
+// Returns the number of tweets dropped because a queue was full
+func (p *Processor) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Waits for the stream to stop and the queued tweets to be handled,
+// then returns the error that stopped the stream
+func (p *Processor) Wait() error {
+	p.wg.Wait()
+	return p.stream.Err()
+}
+
+func (p *Processor) work(queue chan Tweet, handle func(Tweet)) {
+	defer p.wg.Done()
+
+	for tweet := range queue {
+		handle(tweet)
+		atomic.AddUint64(&p.processed, 1)
+	}
+}
+
+// Queues each tweet for the worker owning its user, and passes other
+// messages to onMessage
+func (p *Processor) dispatch() {
+	defer func() {
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}()
+
+	for msg := range p.stream.Messages() {
+		if tweet, ok := msg.(Tweet); ok {
+			p.enqueue(tweet)
+		} else if p.onMessage != nil {
+			p.onMessage(msg)
+		}
+	}
+}
+
+// Queues tweet following the backpressure policy
+func (p *Processor) enqueue(tweet Tweet) {
+	queue := p.queues[uint64(tweet.User.Id)%uint64(len(p.queues))]
+
+	switch p.policy {
+	case Block:
+		queue <- tweet
+	case DropNewest:
+		select {
+		case queue <- tweet:
+		default:
+			atomic.AddUint64(&p.dropped, 1)
+		}
+	case DropOldest:
+		for queued := false; !queued; {
+			select {
+			case queue <- tweet:
+				queued = true
+			default:
+				select {
+				case <-queue:
+					atomic.AddUint64(&p.dropped, 1)
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+func TestProcessSampleStream(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newStreamClient(s)
+
+	stream := tt.SampleStream(SampleParams{Delimited: true})
+	waitForStreams(t, s, 1)
+
+	var mu sync.Mutex
+	seen := map[int64][]int64{}
+	p := stream.Process(ProcessorConfig{Workers: 3}, func(tweet Tweet) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[tweet.User.Id] = append(seen[tweet.User.Id], tweet.Id)
+	})
+
+	for i := 0; i < 20; i++ {
+		for user := int64(1); user <= 5; user++ {
+			s.AddTweet(user, twittertest.Tweet{Text: "sample"})
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Processed() < 100 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	stream.Stop()
+	if err := p.Wait(); err != nil {
+		t.Fatal("Stream failed:", err)
+	}
+
+	if p.Processed() != 100 || p.Dropped() != 0 {
+		t.Errorf("Expected 100 tweets processed and none dropped, got %d and %d", p.Processed(), p.Dropped())
+	}
+	for user, ids := range seen {
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Errorf("Tweets from user %d were handled out of order: %v", user, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestBackpressurePolicy(t *testing.T) {
+	expected := map[BackpressurePolicy][]int64{
+		DropNewest: {1, 2, 3},
+		DropOldest: {1, 9, 10},
+	}
+
+	for policy, ids := range expected {
+		stream := &Stream{ctx: context.Background(), messages: make(chan StreamMessage)}
+
+		started := make(chan bool)
+		release := make(chan bool)
+		var handled []int64
+		p := stream.Process(ProcessorConfig{Workers: 1, QueueSize: 2, Policy: policy}, func(tweet Tweet) {
+			if tweet.Id == 1 {
+				started <- true
+				<-release
+			}
+			handled = append(handled, tweet.Id)
+		})
+
+		stream.messages <- Tweet{Id: 1}
+		<-started
+		for id := int64(2); id <= 10; id++ {
+			stream.messages <- Tweet{Id: id}
+		}
+		close(stream.messages)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for p.Dropped() < 7 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		close(release)
+		p.Wait()
+
+		if p.Dropped() != 7 || len(handled) != len(ids) {
+			t.Errorf("Policy %d: expected %v handled and 7 dropped, got %v and %d", policy, ids, handled, p.Dropped())
+			continue
+		}
+		for i := range ids {
+			if handled[i] != ids[i] {
+				t.Errorf("Policy %d: expected %v handled, got %v", policy, ids, handled)
+				break
+			}
+		}
+	}
+}
+
+func TestProcessorMessages(t *testing.T) {
+	stream := &Stream{ctx: context.Background(), messages: make(chan StreamMessage)}
+
+	var others []StreamMessage
+	p := stream.Process(ProcessorConfig{
+		Workers:   1,
+		OnMessage: func(msg StreamMessage) { others = append(others, msg) },
+	}, func(Tweet) {})
+
+	sent := []StreamMessage{
+		LimitNotice{Track: 5},
+		Tweet{Id: 1},
+		StreamStall{Timeout: time.Second},
+		StreamReconnect{Err: ErrStreamStalled, Delay: time.Millisecond},
+	}
+	for _, msg := range sent {
+		stream.messages <- msg
+	}
+	close(stream.messages)
+	p.Wait()
+
+	if p.Processed() != 1 {
+		t.Errorf("Expected 1 tweet processed, got %d", p.Processed())
+	}
+	expected := []StreamMessage{sent[0], sent[2], sent[3]}
+	if !reflect.DeepEqual(others, expected) {
+		t.Errorf("Expected %v passed to OnMessage, got %v", expected, others)
+	}
+}
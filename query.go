@@ -0,0 +1,267 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The longest query, operators included, that search accepts
+const MaxQueryLength = 500
+
+// Filters for the filter: search operator
+type SearchFilter string
+
+const (
+	FilterRetweets SearchFilter = "retweets"
+	FilterReplies  SearchFilter = "replies"
+	FilterLinks    SearchFilter = "links"
+	FilterMedia    SearchFilter = "media"
+	FilterImages   SearchFilter = "images"
+	FilterVideos   SearchFilter = "videos"
+	FilterVerified SearchFilter = "verified"
+	FilterSafe     SearchFilter = "safe"
+)
+
+var (
+	screenNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,15}$`)
+	langRegexp       = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z]+)?$`)
+)
+
+// A search query, or a part of one, built with the functions below and
+// rendered by BuildQuery:
+//
+//	q, err := BuildQuery(And(
+//		Or(Hashtag("go"), Phrase("go lang")),
+//		From("bsdf"),
+//		Not(Filter(FilterRetweets)),
+//		Since(time.Now().AddDate(0, 0, -7)),
+//	))
+type SearchQuery interface {
+	// Renders the query, negated if negate is set, within a group
+	// joined by sep, or at the top level if sep is empty
+	render(negate bool, sep string) (string, error)
+}
+
+// Renders q as a query string for Search, checking that it is valid
+// and no longer than MaxQueryLength
+func BuildQuery(q SearchQuery) (string, error) {
+	s, err := q.render(false, "")
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", errors.New("empty search query")
+	}
+	if n := utf8.RuneCountInString(s); n > MaxQueryLength {
+		return "", fmt.Errorf("search query is %d characters, longer than %d", n, MaxQueryLength)
+	}
+	return s, nil
+}
+
+// A single term, such as a keyword or an operator
+type term struct {
+	text string
+	err  error
+}
+
+func (t term) render(negate bool, sep string) (string, error) {
+	if t.err != nil {
+		return "", t.err
+	}
+	if negate {
+		return "-" + t.text, nil
+	}
+	return t.text, nil
+}
+
+func invalidTerm(format string, a ...interface{}) term {
+	return term{err: fmt.Errorf(format, a...)}
+}
+
+// Matches tweets containing a keyword. Use Phrase for more than one
+// word and Not to exclude it.
+func Word(word string) SearchQuery {
+	switch {
+	case word == "":
+		return invalidTerm("empty search word")
+	case strings.IndexFunc(word, unicode.IsSpace) >= 0:
+		return invalidTerm("search word %q contains spaces, use Phrase", word)
+	case strings.ContainsAny(word, `"()`):
+		return invalidTerm("search word %q contains quotes or parentheses", word)
+	case strings.HasPrefix(word, "-"):
+		return invalidTerm("search word %q starts with -, use Not", word)
+	case strings.EqualFold(word, "OR"):
+		return invalidTerm("search word %q is an operator, use Or", word)
+	}
+	return term{text: word}
+}
+
+// Matches tweets containing the exact phrase
+func Phrase(phrase string) SearchQuery {
+	switch {
+	case strings.TrimSpace(phrase) == "":
+		return invalidTerm("empty search phrase")
+	case strings.Contains(phrase, `"`):
+		return invalidTerm("search phrase %q contains quotes", phrase)
+	}
+	return term{text: `"` + phrase + `"`}
+}
+
+// Matches tweets tagged with the hashtag, with or without its #
+func Hashtag(tag string) SearchQuery {
+	tag = strings.TrimPrefix(tag, "#")
+	if tag == "" || strings.IndexFunc(tag, isNotTagRune) >= 0 {
+		return invalidTerm("invalid hashtag %q", tag)
+	}
+	return term{text: "#" + tag}
+}
+
+func isNotTagRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+}
+
+// Matches tweets sent by the user
+func From(screenName string) SearchQuery {
+	return userTerm("from:", screenName)
+}
+
+// Matches tweets replying to the user
+func To(screenName string) SearchQuery {
+	return userTerm("to:", screenName)
+}
+
+// Matches tweets mentioning the user
+func Mention(screenName string) SearchQuery {
+	return userTerm("@", screenName)
+}
+
+func userTerm(prefix, screenName string) SearchQuery {
+	screenName = strings.TrimPrefix(screenName, "@")
+	if !screenNameRegexp.MatchString(screenName) {
+		return invalidTerm("invalid screen name %q", screenName)
+	}
+	return term{text: prefix + screenName}
+}
+
+// Matches tweets the filter applies to
+func Filter(filter SearchFilter) SearchQuery {
+	if filter == "" || strings.IndexFunc(string(filter), isNotTagRune) >= 0 {
+		return invalidTerm("invalid search filter %q", filter)
+	}
+	return term{text: "filter:" + string(filter)}
+}
+
+// Matches tweets sent on or after the day of t
+func Since(t time.Time) SearchQuery {
+	return term{text: "since:" + t.Format("2006-01-02")}
+}
+
+// Matches tweets sent before the day of t
+func Until(t time.Time) SearchQuery {
+	return term{text: "until:" + t.Format("2006-01-02")}
+}
+
+// Matches tweets Twitter detected as being in the language, as an
+// ISO 639-1 code
+func Lang(code string) SearchQuery {
+	if !langRegexp.MatchString(code) {
+		return invalidTerm("invalid language code %q", code)
+	}
+	return term{text: "lang:" + code}
+}
+
+// Matches tweets sent within radius of a point. unit is "mi" or "km".
+func Geocode(latitude, longitude, radius float64, unit string) SearchQuery {
+	switch {
+	case latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180:
+		return invalidTerm("invalid coordinates %g,%g", latitude, longitude)
+	case radius <= 0:
+		return invalidTerm("invalid radius %g", radius)
+	case unit != "mi" && unit != "km":
+		return invalidTerm("invalid radius unit %q, use mi or km", unit)
+	}
+	return term{text: fmt.Sprintf("geocode:%g,%g,%g%s", latitude, longitude, radius, unit)}
+}
+
+// Matches tweets matching all of the terms
+func And(terms ...SearchQuery) SearchQuery {
+	return group{terms: terms}
+}
+
+// Matches tweets matching any of the terms
+func Or(terms ...SearchQuery) SearchQuery {
+	return group{terms: terms, or: true}
+}
+
+// Matches tweets not matching q. Negating an And group isn't supported,
+// negate its terms instead.
+func Not(q SearchQuery) SearchQuery {
+	return not{q}
+}
+
+type group struct {
+	terms []SearchQuery
+	or    bool
+}
+
+func (g group) render(negate bool, within string) (string, error) {
+	if len(g.terms) == 0 {
+		return "", errors.New("empty search group")
+	}
+	if len(g.terms) == 1 {
+		return g.terms[0].render(negate, within)
+	}
+
+	sep := " "
+	switch {
+	case negate && !g.or:
+		return "", errors.New("can't negate an And group, negate its terms instead")
+	case g.or && !negate:
+		sep = " OR "
+	}
+
+	// a negated Or group is the And of its negated terms
+	parts := make([]string, len(g.terms))
+	for i, t := range g.terms {
+		s, err := t.render(negate, sep)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = s
+	}
+
+	s := strings.Join(parts, sep)
+	if within != "" && within != sep {
+		s = "(" + s + ")"
+	}
+	return s, nil
+}
+
+type not struct {
+	q SearchQuery
+}
+
+func (n not) render(negate bool, sep string) (string, error) {
+	return n.q.render(!negate, sep)
+}
@@ -0,0 +1,103 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildQuery(t *testing.T) {
+	since := time.Date(2013, 2, 14, 12, 0, 0, 0, time.UTC)
+
+	queries := map[string]SearchQuery{
+		`gucci`:         Word("gucci"),
+		`"gucci mane"`:  Phrase("gucci mane"),
+		`#go from:bsdf`: And(Hashtag("#go"), From("@bsdf")),
+		`(#go OR #golang) @bsdf -filter:retweets`: And(
+			Or(Hashtag("go"), Hashtag("golang")),
+			Mention("bsdf"),
+			Not(Filter(FilterRetweets)),
+		),
+		`to:bsdf since:2013-02-14 until:2013-02-15 lang:en`: And(
+			To("bsdf"),
+			Since(since),
+			Until(since.AddDate(0, 0, 1)),
+			Lang("en"),
+		),
+		`M83 -"children's sneakers" -#ad`:  And(Word("M83"), Not(Or(Phrase("children's sneakers"), Hashtag("ad")))),
+		`(a b) OR c`:                       Or(And(Word("a"), Word("b")), Word("c")),
+		`geocode:37.781157,-122.39872,1mi`: Geocode(37.781157, -122.398720, 1, "mi"),
+		`a`:                                Not(Not(And(Word("a")))),
+	}
+
+	for expected, q := range queries {
+		s, err := BuildQuery(q)
+		if err != nil {
+			t.Errorf("Error building %q: %s", expected, err)
+			continue
+		}
+		if s != expected {
+			t.Errorf("Expected %q, got %q", expected, s)
+		}
+	}
+}
+
+func TestBuildQueryInvalid(t *testing.T) {
+	queries := []SearchQuery{
+		Word("gucci mane"),
+		Word("-gucci"),
+		Word(`"gucci`),
+		Phrase(`say "hi"`),
+		Hashtag("two tags"),
+		From("not a screen name"),
+		To("waytoolongforascreenname"),
+		Lang("english"),
+		Geocode(91, 0, 1, "mi"),
+		Geocode(0, 0, 1, "miles"),
+		And(),
+		Or(Word("a"), Word("b c")),
+		Not(And(Word("a"), Word("b"))),
+		Word(strings.Repeat("a", MaxQueryLength+1)),
+	}
+
+	for i, q := range queries {
+		if s, err := BuildQuery(q); err == nil {
+			t.Errorf("Query %d: expected an error, got %q", i, s)
+		}
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	q, err := BuildQuery(And(Phrase("gucci mane"), From("MEMEMEMEMES"), Not(Filter(FilterLinks))))
+	if err != nil {
+		t.Fatal("Error building query:", err)
+	}
+
+	requests := len(server.Requests())
+	if _, err := tw.Search(q); err != nil {
+		t.Fatal("Error searching:", err.Error())
+	}
+	if len(server.Requests()) <= requests {
+		t.Fatal("Expected a search request")
+	}
+	last := server.Requests()[requests]
+	if last.Query.Get("q") != q {
+		t.Errorf("Expected query %q to be sent, got %q", q, last.Query.Get("q"))
+	}
+}
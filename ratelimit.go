@@ -0,0 +1,249 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extra time waited past a window's reset, which is only reported to
+// the second, to allow for rounding and clock skew
+const DefaultRateLimitSlack = time.Second
+
+const (
+	// Length of a rate limit window, assumed when a rate limited
+	// response doesn't say when it resets
+	rateLimitWindow = 15 * time.Minute
+
+	// Times a request is re-sent after rate limited responses before
+	// the error is returned
+	maxRateLimitWaits = 3
+)
+
+// The state of a rate limit window
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+// Decodes a rate limit from application/rate_limit_status, where
+// reset is given in epoch seconds
+func (l *RateLimit) UnmarshalJSON(data []byte) error {
+	var raw = struct {
+		Limit     int
+		Remaining int
+		Reset     int64
+	}{}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	l.Limit = raw.Limit
+	l.Remaining = raw.Remaining
+	l.Reset = time.Unix(raw.Reset, 0)
+	return nil
+}
+
+// Reports whether no requests remain before the window resets
+func (l RateLimit) Exhausted() bool {
+	return l.Remaining <= 0 && time.Now().Before(l.Reset)
+}
+
+// Returns how long until the window resets
+func (l RateLimit) ResetIn() time.Duration {
+	if d := l.Reset.Sub(time.Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Returns the rate limit last reported for endpoint, eg.
+// "/statuses/user_timeline" or "/statuses/retweet/:id". ok is false
+// until a request to the endpoint has been made.
+func (t *Twitter) RateLimit(endpoint string) (limit RateLimit, ok bool) {
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+
+	limit, ok = t.rateLimits[endpoint]
+	return
+}
+
+// Returns the rate limits reported so far, keyed by endpoint. If any
+// families are given, eg. "statuses" or "search", only endpoints in
+// those families are returned.
+func (t *Twitter) RateLimits(families ...string) map[string]RateLimit {
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+
+	limits := make(map[string]RateLimit)
+	for endpoint, limit := range t.rateLimits {
+		if len(families) == 0 || containsString(families, endpointFamily(endpoint)) {
+			limits[endpoint] = limit
+		}
+	}
+	return limits
+}
+
+// Records the rate limit reported in a response to m
+func (t *Twitter) recordRateLimit(m *RestMethod, header http.Header) {
+	limit, ok := parseRateLimit(header)
+	if !ok {
+		return
+	}
+
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+
+	if t.rateLimits == nil {
+		t.rateLimits = make(map[string]RateLimit)
+	}
+	t.rateLimits[t.endpoint(m.Url)] = limit
+}
+
+// Blocks until the tracked limit for m's endpoint resets, if it is
+// exhausted and WaitOnRateLimit is set
+func (t *Twitter) waitForRateLimit(ctx context.Context, m *RestMethod) error {
+	if !t.WaitOnRateLimit {
+		return nil
+	}
+
+	endpoint := t.endpoint(m.Url)
+	limit, ok := t.RateLimit(endpoint)
+	if !ok || !limit.Exhausted() {
+		return nil
+	}
+
+	if t.OnRateLimit != nil {
+		if err := t.OnRateLimit(endpoint, limit); err != nil {
+			return err
+		}
+	}
+
+	return sleep(ctx, limit.ResetIn()+t.rateLimitSlack())
+}
+
+// Records m's endpoint as exhausted after a rate limited response,
+// even if the response didn't report a usable reset time. Returns the
+// recorded limit, which is no longer exhausted if its reset had passed.
+func (t *Twitter) markRateLimited(m *RestMethod, err error) RateLimit {
+	limit := RateLimit{}
+	if apiErr, ok := asAPIError(err); ok {
+		limit = apiErr.RateLimit
+	}
+
+	limit.Remaining = 0
+	if limit.Reset.IsZero() {
+		limit.Reset = time.Now().Add(rateLimitWindow)
+	}
+	if now := time.Now(); limit.Reset.Before(now) {
+		limit.Reset = now
+	}
+
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
+
+	if t.rateLimits == nil {
+		t.rateLimits = make(map[string]RateLimit)
+	}
+	t.rateLimits[t.endpoint(m.Url)] = limit
+	return limit
+}
+
+// How long to pause before re-sending a request that was rate limited
+// with a reset that had already passed, for the given wait. The reset
+// can't be trusted, so this is the Retry backoff, but never less than
+// the rate limit slack.
+func (t *Twitter) staleResetPause(wait int) time.Duration {
+	d := t.rateLimitSlack()
+	if t.Retry != nil {
+		if backoff := t.Retry.backoff(wait); backoff > d {
+			d = backoff
+		}
+	}
+	return d
+}
+
+func (t *Twitter) rateLimitSlack() time.Duration {
+	if t.RateLimitSlack > 0 {
+		return t.RateLimitSlack
+	}
+	return DefaultRateLimitSlack
+}
+
+// Returns the endpoint a request url is rate limited under: its path
+// relative to the API version, without the .json extension and with
+// ids replaced by :id
+func (t *Twitter) endpoint(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+
+	path := u.Path
+	for _, base := range []string{t.apiUrl(""), t.uploadUrl("")} {
+		if b, err := url.Parse(base); err == nil && strings.HasPrefix(path, b.Path) {
+			path = "/" + strings.TrimPrefix(path, b.Path)
+			break
+		}
+	}
+
+	segments := strings.Split(strings.TrimSuffix(path, ".json"), "/")
+	for i, segment := range segments {
+		if _, err := strconv.ParseInt(segment, 10, 64); err == nil {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Returns the family of an endpoint, eg. "statuses" for
+// "/statuses/user_timeline"
+func endpointFamily(endpoint string) string {
+	return strings.SplitN(strings.TrimPrefix(endpoint, "/"), "/", 2)[0]
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the x-rate-limit-* headers of a response. ok is false if the
+// response carried none.
+func parseRateLimit(header http.Header) (limit RateLimit, ok bool) {
+	if header.Get("X-Rate-Limit-Limit") == "" {
+		return
+	}
+
+	limit.Limit, _ = strconv.Atoi(header.Get("X-Rate-Limit-Limit"))
+	limit.Remaining, _ = strconv.Atoi(header.Get("X-Rate-Limit-Remaining"))
+	if reset, err := strconv.ParseInt(header.Get("X-Rate-Limit-Reset"), 10, 64); err == nil {
+		limit.Reset = time.Unix(reset, 0)
+	}
+	return limit, true
+}
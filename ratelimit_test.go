@@ -0,0 +1,234 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+func TestEndpoint(t *testing.T) {
+	var tt Twitter
+
+	urls := map[string]string{
+		"https://api.twitter.com/1.1/statuses/user_timeline.json?screen_name=bsdf": "/statuses/user_timeline",
+		"https://api.twitter.com/1.1/statuses/retweet/221281838440783875.json":     "/statuses/retweet/:id",
+		"https://upload.twitter.com/1.1/media/upload.json":                         "/media/upload",
+		"https://api.twitter.com/oauth2/token":                                     "/oauth2/token",
+	}
+
+	for url, expected := range urls {
+		if endpoint := tt.endpoint(url); endpoint != expected {
+			t.Errorf("Expected endpoint %s for %s, got %s", expected, url, endpoint)
+		}
+	}
+}
+
+func TestRateLimitTracking(t *testing.T) {
+	if _, err := tw.GetUserTimeline("bsdf"); err != nil {
+		t.Error("Error retrieving user timeline:", err.Error())
+		return
+	}
+
+	first, ok := tw.RateLimit("/statuses/user_timeline")
+	if !ok {
+		t.Error("Rate limit was not recorded")
+		return
+	}
+	if first.Limit == 0 || first.Reset.Before(time.Now()) {
+		t.Errorf("Rate limit was not parsed: %+v", first)
+		return
+	}
+
+	if _, err := tw.GetUserTimeline("bsdf"); err != nil {
+		t.Error("Error retrieving user timeline:", err.Error())
+		return
+	}
+
+	second, _ := tw.RateLimit("/statuses/user_timeline")
+	if second.Remaining != first.Remaining-1 {
+		t.Errorf("Expected %d remaining, got %d", first.Remaining-1, second.Remaining)
+	}
+	if second.Exhausted() {
+		t.Error("Rate limit reported exhausted with requests remaining")
+	}
+
+	if _, err := tw.Search("gucci"); err != nil {
+		t.Error("Error searching:", err.Error())
+		return
+	}
+
+	limits := tw.RateLimits("search")
+	if _, ok := limits["/search/tweets"]; !ok || len(limits) != 1 {
+		t.Errorf("Expected only the search family, got %v", limits)
+	}
+	if _, ok := tw.RateLimits()["/statuses/user_timeline"]; !ok {
+		t.Error("Unfiltered rate limits did not include every endpoint")
+	}
+}
+
+func TestRateLimitExhausted(t *testing.T) {
+	limit := RateLimit{Limit: 15, Remaining: 0, Reset: time.Now().Add(time.Minute)}
+	if !limit.Exhausted() || limit.ResetIn() <= 0 {
+		t.Error("Rate limit with no requests remaining was not exhausted")
+	}
+
+	limit.Reset = time.Now().Add(-time.Minute)
+	if limit.Exhausted() || limit.ResetIn() != 0 {
+		t.Error("Rate limit was exhausted after its window reset")
+	}
+}
+
+// Starts a fake server allowing one request to help/tos per one
+// second window
+func newRateLimitedServer() *twittertest.Server {
+	s := newTestServer()
+	s.RateLimitWindow = time.Second
+	s.SetRateLimit("/help/tos", 1)
+	return s
+}
+
+func newWaitingClient(s *twittertest.Server) *Twitter {
+	tt := newClient(s)
+	tt.WaitOnRateLimit = true
+	tt.RateLimitSlack = 10 * time.Millisecond
+	return tt
+}
+
+func TestWaitOnRateLimit(t *testing.T) {
+	s := newRateLimitedServer()
+	defer s.Close()
+	tt := newWaitingClient(s)
+
+	var waited []string
+	tt.OnRateLimit = func(endpoint string, limit RateLimit) error {
+		waited = append(waited, endpoint)
+		return nil
+	}
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := tt.GetTOS(); err != nil {
+			t.Fatal("Error retrieving TOS:", err.Error())
+		}
+	}
+
+	if len(waited) != 1 || waited[0] != "/help/tos" {
+		t.Errorf("Expected one wait on /help/tos, got %v", waited)
+	}
+	if time.Since(start) < s.RateLimitWindow {
+		t.Error("Request was not delayed until the window reset")
+	}
+}
+
+func TestWaitOnRateLimitResponse(t *testing.T) {
+	s := newRateLimitedServer()
+	defer s.Close()
+	tt := newWaitingClient(s)
+
+	// exhaust the window from another client, so tt only finds out
+	// from the 429
+	other := newWaitingClient(s)
+	other.WaitOnRateLimit = false
+	if _, err := other.GetTOS(); err != nil {
+		t.Fatal("Error retrieving TOS:", err.Error())
+	}
+	if _, err := other.GetTOS(); !IsRateLimited(err) {
+		t.Fatal("Expected a rate limit error, got", err)
+	}
+
+	if _, err := tt.GetTOS(); err != nil {
+		t.Error("Rate limited request was not retried:", err.Error())
+	}
+}
+
+func TestWaitOnRateLimitAbort(t *testing.T) {
+	s := newRateLimitedServer()
+	defer s.Close()
+	tt := newWaitingClient(s)
+
+	abort := errors.New("not waiting")
+	tt.OnRateLimit = func(endpoint string, limit RateLimit) error {
+		return abort
+	}
+
+	tt.GetTOS()
+	if _, err := tt.GetTOS(); err != abort {
+		t.Errorf("Expected the callback's error, got %v", err)
+	}
+
+	tt.OnRateLimit = nil
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := tt.GetTOSContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the context's error while waiting, got %v", err)
+	}
+}
+
+func TestWaitOnStaleRateLimit(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newWaitingClient(s)
+
+	stale := twittertest.Error{
+		Status:  429,
+		Code:    88,
+		Message: "Rate limit exceeded",
+		Header: http.Header{
+			"X-Rate-Limit-Limit":     {"15"},
+			"X-Rate-Limit-Remaining": {"0"},
+			"X-Rate-Limit-Reset":     {strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)},
+		},
+	}
+	countTOS := func() (n int) {
+		for _, r := range s.Requests() {
+			if r.Path == "/1.1/help/tos.json" {
+				n++
+			}
+		}
+		return
+	}
+
+	// a reset that has already passed still pauses before re-sending
+	s.InjectError("/1.1/help/tos.json", stale)
+	start := time.Now()
+	if _, err := tt.GetTOS(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < tt.RateLimitSlack {
+		t.Errorf("Expected a pause of at least %v before re-sending, got %v", tt.RateLimitSlack, elapsed)
+	}
+
+	// and a request is only re-sent a few times
+	for i := 0; i <= maxRateLimitWaits; i++ {
+		s.InjectError("/1.1/help/tos.json", stale)
+	}
+	before := countTOS()
+	if _, err := tt.GetTOS(); !IsRateLimited(err) {
+		t.Errorf("Expected a rate limit error, got %v", err)
+	}
+	if n := countTOS() - before; n != maxRateLimitWaits+1 {
+		t.Errorf("Expected %d requests, got %d", maxRateLimitWaits+1, n)
+	}
+}
@@ -0,0 +1,133 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Controls how failed requests are retried. Network errors are always
+// retryable and other errors never are; API errors are retryable if their status or one of their
+// error codes is listed.
+type RetryPolicy struct {
+	// Total number of attempts, including the first
+	MaxAttempts int
+
+	// Delay before the first retry, doubling for each one after up
+	// to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Fraction of each delay, from 0 to 1, that is randomised so that
+	// clients don't retry in lockstep
+	Jitter float64
+
+	// Statuses and error codes worth retrying
+	RetryStatuses []int
+	RetryCodes    []int
+
+	// Retry POSTs too. They aren't idempotent, so a retried Tweet may be
+	// posted twice if the first attempt reached Twitter.
+	RetryPost bool
+}
+
+// Retries server errors and over capacity responses a few times
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	Jitter:      0.5,
+	RetryStatuses: []int{
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+	RetryCodes: []int{ErrCodeOverCapacity, ErrCodeInternalError},
+}
+
+// Reports whether the failed attempt at m should be retried
+func (p *RetryPolicy) retryable(ctx context.Context, m *RestMethod, attempt int, err error) bool {
+	if attempt >= p.MaxAttempts || ctx.Err() != nil {
+		return false
+	}
+	if m.Method == "POST" && !p.RetryPost {
+		return false
+	}
+
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return networkError(err)
+	}
+
+	for _, status := range p.RetryStatuses {
+		if apiErr.StatusCode == status {
+			return true
+		}
+	}
+	for _, code := range p.RetryCodes {
+		if apiErr.HasCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether err is a failure to reach Twitter or to read its
+// response, rather than a mistake that would only be repeated
+func networkError(err error) bool {
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// Returns how long to wait before the given retry, counting from 1
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Sleeps for d, returning early with ctx's error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
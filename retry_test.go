@@ -0,0 +1,168 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+// Returns a client for s that retries quickly
+func newRetryingClient(s *twittertest.Server, policy RetryPolicy) *Twitter {
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 10 * time.Millisecond
+
+	tt := newClient(s)
+	tt.Retry = &policy
+	return tt
+}
+
+func TestRetry(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newRetryingClient(s, DefaultRetryPolicy)
+
+	s.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 503, Body: "Over capacity"})
+	s.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 403, Code: ErrCodeOverCapacity, Message: "Over capacity"})
+
+	if _, err := tt.GetTOS(); err != nil {
+		t.Fatal("Request was not retried:", err.Error())
+	}
+
+	requests := s.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(requests))
+	}
+
+	nonces := map[string]bool{}
+	for _, r := range requests {
+		nonces[r.Header.Get("Authorization")] = true
+	}
+	if len(nonces) != len(requests) {
+		t.Error("Attempts were not signed afresh")
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newRetryingClient(s, RetryPolicy{MaxAttempts: 2, RetryStatuses: []int{503}})
+
+	for i := 0; i < 3; i++ {
+		s.InjectError("/1.1/help/tos.json", twittertest.Error{Status: 503})
+	}
+
+	if _, err := tt.GetTOS(); err == nil {
+		t.Fatal("Expected an error after running out of attempts")
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Expected 2 attempts, got %d", n)
+	}
+
+	s.InjectError("/1.1/help/privacy.json", twittertest.Error{Status: 401})
+	tt.GetPrivacyPolicy()
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("Unretryable error was retried, %d requests made", n-2)
+	}
+}
+
+func TestRetryPost(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newRetryingClient(s, DefaultRetryPolicy)
+
+	s.InjectError("/1.1/statuses/update.json", twittertest.Error{Status: 503})
+	if _, err := tt.Tweet("not retried"); err == nil {
+		t.Error("POST was retried without RetryPost")
+	}
+
+	tt.Retry.RetryPost = true
+	s.InjectError("/1.1/statuses/update.json", twittertest.Error{Status: 503})
+	if _, err := tt.Tweet("retried"); err != nil {
+		t.Error("POST was not retried with RetryPost:", err.Error())
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newRetryingClient(s, DefaultRetryPolicy)
+
+	failures := 2
+	tt.HttpClient = &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("connection reset")
+			}
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+
+	if _, err := tt.GetTOS(); err != nil {
+		t.Error("Network error was not retried:", err.Error())
+	}
+}
+
+func TestRetryOtherError(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newRetryingClient(s, DefaultRetryPolicy)
+
+	corrupt := errors.New("corrupt response")
+	tt.HttpClient = &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err == nil {
+				resp.Body.Close()
+				resp.Body = ioutil.NopCloser(iotest.ErrReader(corrupt))
+			}
+			return resp, err
+		}),
+	}
+
+	if _, err := tt.GetTOS(); !errors.Is(err, corrupt) {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("Expected 1 attempt, got %d", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if backoff := p.backoff(i + 1); backoff != d {
+			t.Errorf("Retry %d: expected %s, got %s", i+1, d, backoff)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if backoff := p.backoff(2); backoff < time.Second || backoff > 2*time.Second {
+			t.Errorf("Jittered backoff %s out of range", backoff)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Result types for SearchParams
+const (
+	ResultMixed   = "mixed"
+	ResultRecent  = "recent"
+	ResultPopular = "popular"
+)
+
+// Options for searching tweets. Only Query is required.
+type SearchParams struct {
+	Query string
+
+	// Restrict results to a language, as an ISO 639-1 code
+	Lang string
+
+	// Language of the query, only "ja" is effective
+	Locale string
+
+	// One of ResultMixed, ResultRecent or ResultPopular
+	ResultType string
+
+	// Restrict results to users near a point, as "latitude,longitude,radius"
+	// with the radius in "mi" or "km", eg. "37.781157,-122.398720,1mi"
+	Geocode string
+
+	// Only return tweets created before this date
+	Until time.Time
+
+	// Only return tweets newer than SinceId and no newer than MaxId
+	SinceId int64
+	MaxId   int64
+
+	// Tweets per page, at most 100. Twitter's default is used if zero.
+	Count int
+}
+
+// Searches recent tweets, returning one page of results. Use
+// NextSearchResults to fetch the pages after it.
+func (t *Twitter) SearchWithParams(params SearchParams) (result SearchResult, err error) {
+	return t.SearchWithParamsContext(context.Background(), params)
+}
+
+// SearchWithParams with a context for cancellation and deadlines
+func (t *Twitter) SearchWithParamsContext(ctx context.Context, params SearchParams) (result SearchResult, err error) {
+	return t.search(ctx, params.values())
+}
+
+// Reports whether there is a page of older results after result
+func (r SearchResult) HasNext() bool {
+	return r.Metadata.NextResults != ""
+}
+
+// Fetches the page of results after result
+func (t *Twitter) NextSearchResults(result SearchResult) (SearchResult, error) {
+	return t.NextSearchResultsContext(context.Background(), result)
+}
+
+// NextSearchResults with a context for cancellation and deadlines
+func (t *Twitter) NextSearchResultsContext(ctx context.Context, result SearchResult) (next SearchResult, err error) {
+	if !result.HasNext() {
+		return next, errors.New("no more search results")
+	}
+
+	v, err := url.ParseQuery(strings.TrimPrefix(result.Metadata.NextResults, "?"))
+	if err != nil {
+		return
+	}
+	return t.search(ctx, v)
+}
+
+func (t *Twitter) search(ctx context.Context, v url.Values) (result SearchResult, err error) {
+	method := &RestMethod{
+		// re-encode v as next_results may not be encoded the way
+		// it is signed
+		Url:    t.apiUrl("search/tweets.json?" + queryString(v)),
+		Method: "GET",
+		auth:   appAuth,
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &result)
+	result.Query = v.Get("q")
+	return
+}
+
+func (p SearchParams) values() url.Values {
+	v := url.Values{}
+	v.Set("q", p.Query)
+	if p.Lang != "" {
+		v.Set("lang", p.Lang)
+	}
+	if p.Locale != "" {
+		v.Set("locale", p.Locale)
+	}
+	if p.ResultType != "" {
+		v.Set("result_type", p.ResultType)
+	}
+	if p.Geocode != "" {
+		v.Set("geocode", p.Geocode)
+	}
+	if !p.Until.IsZero() {
+		v.Set("until", p.Until.Format("2006-01-02"))
+	}
+	if p.SinceId > 0 {
+		v.Set("since_id", strconv.FormatInt(p.SinceId, 10))
+	}
+	if p.MaxId > 0 {
+		v.Set("max_id", strconv.FormatInt(p.MaxId, 10))
+	}
+	if p.Count > 0 {
+		v.Set("count", strconv.Itoa(p.Count))
+	}
+	return v
+}
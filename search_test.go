@@ -0,0 +1,104 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+func TestSearchParams(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	until := time.Date(2013, 2, 14, 0, 0, 0, 0, time.UTC)
+	result, err := tt.SearchWithParams(SearchParams{
+		Query:      "gucci mane",
+		Lang:       "en",
+		Locale:     "ja",
+		ResultType: ResultRecent,
+		Geocode:    "37.781157,-122.398720,1mi",
+		Until:      until,
+		SinceId:    1,
+		Count:      5,
+	})
+	if err != nil {
+		t.Fatal("Error searching:", err.Error())
+	}
+	if len(result.Results) != 1 || result.Query != "gucci mane" || result.HasNext() {
+		t.Errorf("Unexpected search result: %+v", result)
+	}
+
+	requests := s.Requests()
+	query := requests[len(requests)-1].Query
+	expected := map[string]string{
+		"q":           "gucci mane",
+		"lang":        "en",
+		"locale":      "ja",
+		"result_type": "recent",
+		"geocode":     "37.781157,-122.398720,1mi",
+		"until":       "2013-02-14",
+		"since_id":    "1",
+		"count":       "5",
+	}
+	for k, v := range expected {
+		if query.Get(k) != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, query.Get(k))
+		}
+	}
+}
+
+func TestSearchPaging(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	for i := 0; i < 7; i++ {
+		s.AddTweet(14114455, twittertest.Tweet{Text: "M83 and gucci mane*"})
+	}
+
+	result, err := tt.SearchWithParams(SearchParams{Query: "gucci mane*", Count: 3})
+	if err != nil {
+		t.Fatal("Error searching:", err.Error())
+	}
+
+	seen := map[int64]bool{}
+	pages := 1
+	for {
+		for _, tweet := range result.Results {
+			seen[tweet.Id] = true
+		}
+		if !result.HasNext() {
+			break
+		}
+
+		if result, err = tt.NextSearchResults(result); err != nil {
+			t.Fatal("Error fetching next results:", err.Error())
+		}
+		pages++
+	}
+
+	if len(seen) != 7 || pages != 3 {
+		t.Errorf("Expected 7 tweets over 3 pages, got %d over %d", len(seen), pages)
+	}
+	if _, err := tt.NextSearchResults(result); err == nil {
+		t.Error("Expected an error after the last page")
+	}
+}
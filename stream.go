@@ -0,0 +1,461 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delays between attempts to reconnect a stream, following Twitter's
+// rules. Each kind of delay starts over once a connection has delivered
+// a message or stayed up for a while. Dropped connections count as
+// network errors.
+type StreamBackoff struct {
+	// Network errors back off linearly by NetworkStep, up to NetworkMax
+	NetworkStep time.Duration
+	NetworkMax  time.Duration
+
+	// HTTP errors back off exponentially from HTTPStart up to HTTPMax
+	HTTPStart time.Duration
+	HTTPMax   time.Duration
+
+	// Rate limited connections, with a 420 or 429, back off
+	// exponentially from RateLimitStart up to RateLimitMax
+	RateLimitStart time.Duration
+	RateLimitMax   time.Duration
+}
+
+// The reconnect delays Twitter asks clients to use
+var DefaultStreamBackoff = StreamBackoff{
+	NetworkStep:    250 * time.Millisecond,
+	NetworkMax:     16 * time.Second,
+	HTTPStart:      5 * time.Second,
+	HTTPMax:        320 * time.Second,
+	RateLimitStart: time.Minute,
+	RateLimitMax:   16 * time.Minute,
+}
+
+// How long a stream may go without receiving anything, not even a
+// keep-alive, before it is considered stalled and reconnected
+const DefaultStallTimeout = 90 * time.Second
+
+// Returned, and sent as a StreamStall, when a connection goes silent
+var ErrStreamStalled = errors.New("stream stalled")
+
+// How long a connection must stay up, if it delivers no messages, to
+// count as working and clear the reconnect backoff
+const streamHealthyAfter = 30 * time.Second
+
+// Largest length delimited message read, far above any Tweet, so a
+// corrupt length can't allocate without bound
+const maxStreamMessage = 1 << 20
+
+// A bounding box for FilterParams.Locations, in degrees
+type BoundingBox struct {
+	West, South, East, North float64
+}
+
+// Selects the tweets a filter stream delivers. At least one of Track,
+// Follow or Locations must be set; tweets matching any of them are sent.
+type FilterParams struct {
+	// Phrases to match. A tweet matches a phrase if it contains all of
+	// its words.
+	Track []string
+
+	// Users whose tweets to deliver
+	Follow []int64
+
+	// Areas to deliver geotagged tweets from
+	Locations []BoundingBox
+
+	// Frame messages by length rather than by newline
+	Delimited bool
+}
+
+// A long-lived connection to a streaming endpoint, reconnected with
+// backoff whenever it drops. Messages are delivered until the stream is
+// stopped or fails with an error that reconnecting won't fix.
+type Stream struct {
+	t         *Twitter
+	ctx       context.Context
+	cancel    context.CancelFunc
+	method    RestMethod
+	delimited bool
+
+	messages   chan StreamMessage
+	tweets     chan Tweet
+	tweetsOnce sync.Once
+	done       chan struct{}
+	err        error
+
+	// set when Twitter sends a disconnect not worth reconnecting after
+	fatal error
+}
+
+// Opens a stream of tweets matching params from statuses/filter
+func (t *Twitter) FilterStream(params FilterParams) (*Stream, error) {
+	return t.FilterStreamContext(context.Background(), params)
+}
+
+// FilterStream with a context. Cancelling it stops the stream.
+func (t *Twitter) FilterStreamContext(ctx context.Context, params FilterParams) (*Stream, error) {
+	v := url.Values{}
+	if len(params.Track) > 0 {
+		v.Set("track", strings.Join(params.Track, ","))
+	}
+	if len(params.Follow) > 0 {
+		ids := make([]string, len(params.Follow))
+		for i, id := range params.Follow {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		v.Set("follow", strings.Join(ids, ","))
+	}
+	if len(params.Locations) > 0 {
+		var coords []string
+		for _, box := range params.Locations {
+			for _, c := range []float64{box.West, box.South, box.East, box.North} {
+				coords = append(coords, strconv.FormatFloat(c, 'f', -1, 64))
+			}
+		}
+		v.Set("locations", strings.Join(coords, ","))
+	}
+	if len(v) == 0 {
+		return nil, errors.New("filter stream needs track, follow or locations")
+	}
+	if params.Delimited {
+		v.Set("delimited", "length")
+	}
+
+	method := RestMethod{
+		Url:    t.streamUrl("statuses/filter.json"),
+		Method: "POST",
+		Data:   queryString(v),
+	}
+	return t.openStream(ctx, method, params.Delimited), nil
+}
+
+// Options for the sample stream
+type SampleParams struct {
+	// Frame messages by length rather than by newline
+	Delimited bool
+}
+
+// Opens a stream of a small random sample of all public tweets from
+// statuses/sample
+func (t *Twitter) SampleStream(params SampleParams) *Stream {
+	return t.SampleStreamContext(context.Background(), params)
+}
+
+// SampleStream with a context. Cancelling it stops the stream.
+func (t *Twitter) SampleStreamContext(ctx context.Context, params SampleParams) *Stream {
+	path := "statuses/sample.json"
+	if params.Delimited {
+		path += "?delimited=length"
+	}
+
+	method := RestMethod{
+		Url:    t.streamUrl(path),
+		Method: "GET",
+	}
+	return t.openStream(ctx, method, params.Delimited)
+}
+
+func (t *Twitter) openStream(ctx context.Context, m RestMethod, delimited bool) *Stream {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Stream{
+		t:         t,
+		ctx:       ctx,
+		cancel:    cancel,
+		method:    m,
+		delimited: delimited,
+		messages:  make(chan StreamMessage),
+		done:      make(chan struct{}),
+	}
+
+	go s.run()
+	return s
+}
+
+// Returns the channel messages are delivered on, in the order they
+// arrive. It is closed when the stream stops.
+func (s *Stream) Messages() <-chan StreamMessage {
+	return s.messages
+}
+
+// Returns a channel of just the tweets, discarding other messages. It
+// is closed when the stream stops. Use either Tweets or Messages, not
+// both.
+func (s *Stream) Tweets() <-chan Tweet {
+	s.tweetsOnce.Do(func() {
+		s.tweets = make(chan Tweet)
+		go func() {
+			defer close(s.tweets)
+			for msg := range s.messages {
+				if tweet, ok := msg.(Tweet); ok {
+					select {
+					case s.tweets <- tweet:
+					case <-s.ctx.Done():
+					}
+				}
+			}
+		}()
+	})
+	return s.tweets
+}
+
+// Passes each message to its handler until the stream stops, then
+// returns the error that stopped it
+func (s *Stream) Handle(h StreamHandlers) error {
+	for msg := range s.messages {
+		h.Handle(msg)
+	}
+	return s.err
+}
+
+// Disconnects the stream and waits for it to stop
+func (s *Stream) Stop() {
+	s.cancel()
+	<-s.done
+}
+
+// Returns the error that stopped the stream, if any. Only valid once
+// the stream has stopped.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Connects and reconnects until the stream is stopped or fails
+func (s *Stream) run() {
+	defer close(s.done)
+	defer close(s.messages)
+
+	backoff := s.t.StreamBackoff
+	if backoff == nil {
+		backoff = &DefaultStreamBackoff
+	}
+
+	var networkDelay, httpDelay, limitDelay time.Duration
+	for {
+		healthy, err := s.connect()
+		if s.ctx.Err() != nil {
+			return
+		}
+		if s.fatal != nil {
+			s.err = s.fatal
+			return
+		}
+
+		// only a connection that worked for a while clears the backoff,
+		// so one that is accepted and dropped at once can't spin
+		if healthy {
+			networkDelay, httpDelay, limitDelay = 0, 0, 0
+		}
+
+		var delay time.Duration
+		apiErr, ok := asAPIError(err)
+		switch {
+		case err == ErrStreamStalled:
+			s.deliver(StreamStall{Timeout: s.stallTimeout()})
+			networkDelay = minDuration(networkDelay+backoff.NetworkStep, backoff.NetworkMax)
+			delay = networkDelay
+		case !ok:
+			// drops and failures to connect back off linearly
+			networkDelay = minDuration(networkDelay+backoff.NetworkStep, backoff.NetworkMax)
+			delay = networkDelay
+		case apiErr.StatusCode == 420 || apiErr.StatusCode == http.StatusTooManyRequests:
+			limitDelay = nextDelay(limitDelay, backoff.RateLimitStart, backoff.RateLimitMax)
+			delay = limitDelay
+		case apiErr.StatusCode >= 400 && apiErr.StatusCode < 500:
+			// bad credentials or parameters won't get any better
+			s.err = err
+			return
+		default:
+			httpDelay = nextDelay(httpDelay, backoff.HTTPStart, backoff.HTTPMax)
+			delay = httpDelay
+		}
+
+		s.deliver(StreamReconnect{Err: err, Delay: delay})
+		if sleep(s.ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// Makes one connection, delivering messages until it drops. Reports
+// whether the connection was healthy, having delivered a message or
+// stayed up for streamHealthyAfter, and why it ended.
+func (s *Stream) connect() (healthy bool, err error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	// tear the connection down if nothing arrives in time
+	var stalled int32
+	timeout := s.stallTimeout()
+	watchdog := time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&stalled, 1)
+		cancel()
+	})
+	defer watchdog.Stop()
+
+	defer func() {
+		if atomic.LoadInt32(&stalled) == 1 {
+			err = ErrStreamStalled
+		}
+	}()
+
+	// sign a copy so every attempt gets a fresh nonce and timestamp
+	m := s.method
+	req, err := s.t.newRequest(ctx, &m)
+	if err != nil {
+		return
+	}
+
+	resp, err := s.t.client().Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return false, newAPIError(&m, resp, body)
+	}
+
+	start := time.Now()
+	delivered := false
+	defer func() {
+		healthy = delivered || time.Since(start) >= streamHealthyAfter
+	}()
+
+	r := bufio.NewReader(&activityReader{resp.Body, watchdog, timeout})
+	for {
+		msg, err := readMessage(r, s.delimited)
+		if err != nil {
+			return false, err
+		}
+		if len(msg) == 0 {
+			// keep-alive
+			continue
+		}
+
+		if s.t.DebugMode {
+			fmt.Printf("Stream message:\n%s\n\n", msg)
+		}
+		// a slow consumer isn't a stalled connection, so the watchdog
+		// only times network silence
+		watchdog.Stop()
+		s.handle(msg)
+		watchdog.Reset(timeout)
+		delivered = true
+	}
+}
+
+// Decodes and delivers a message. Malformed messages are dropped.
+func (s *Stream) handle(b []byte) {
+	msg, err := DecodeStreamMessage(b)
+	if err != nil {
+		return
+	}
+
+	if d, ok := msg.(Disconnect); ok && d.Fatal() {
+		s.fatal = d
+	}
+	s.deliver(msg)
+}
+
+// Sends msg to the consumer unless the stream is stopped first
+func (s *Stream) deliver(msg StreamMessage) {
+	select {
+	case s.messages <- msg:
+	case <-s.ctx.Done():
+	}
+}
+
+func (s *Stream) stallTimeout() time.Duration {
+	if s.t.StreamStallTimeout > 0 {
+		return s.t.StreamStallTimeout
+	}
+	return DefaultStallTimeout
+}
+
+// Resets a stall timer whenever data arrives
+type activityReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.timer.Reset(a.timeout)
+	}
+	return n, err
+}
+
+// Reads a newline or length delimited message. Keep-alives are returned
+// as empty messages.
+func readMessage(r *bufio.Reader, delimited bool) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = bytes.TrimSpace(line)
+	if !delimited || len(line) == 0 {
+		return line, nil
+	}
+
+	n, err := strconv.Atoi(string(line))
+	if err != nil || n < 0 || n > maxStreamMessage {
+		return nil, fmt.Errorf("bad stream message length %q", line)
+	}
+
+	msg := make([]byte, n)
+	if _, err = io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(msg), nil
+}
+
+// Returns the delay after prev when doubling from start up to max
+func nextDelay(prev, start, max time.Duration) time.Duration {
+	if prev == 0 {
+		return start
+	}
+	return minDuration(prev*2, max)
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
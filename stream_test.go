@@ -0,0 +1,349 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+var fastBackoff = StreamBackoff{
+	NetworkStep:    time.Millisecond,
+	NetworkMax:     5 * time.Millisecond,
+	HTTPStart:      time.Millisecond,
+	HTTPMax:        5 * time.Millisecond,
+	RateLimitStart: time.Millisecond,
+	RateLimitMax:   5 * time.Millisecond,
+}
+
+// Returns a client for s that reconnects streams quickly
+func newStreamClient(s *twittertest.Server) *Twitter {
+	tt := newClient(s)
+	backoff := fastBackoff
+	tt.StreamBackoff = &backoff
+	return tt
+}
+
+// Waits until s has n connected streams
+func waitForStreams(t *testing.T, s *twittertest.Server, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Streams() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d connected streams, got %d", n, s.Streams())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Receives the next tweet from stream, failing after a timeout
+func nextTweet(t *testing.T, stream *Stream) Tweet {
+	select {
+	case tweet, ok := <-stream.Tweets():
+		if !ok {
+			t.Fatal("Stream stopped:", stream.Err())
+		}
+		return tweet
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a tweet")
+	}
+	return Tweet{}
+}
+
+func TestFilterStream(t *testing.T) {
+	for _, delimited := range []bool{false, true} {
+		s := newTestServer()
+		tt := newStreamClient(s)
+
+		stream, err := tt.FilterStream(FilterParams{
+			Track:     []string{"gucci mane", "M83"},
+			Follow:    []int64{14114455},
+			Locations: []BoundingBox{{-122.75, 36.8, -121.75, 37.8}},
+			Delimited: delimited,
+		})
+		if err != nil {
+			t.Fatal("Error opening stream:", err.Error())
+		}
+		waitForStreams(t, s, 1)
+
+		s.AddTweet(76395009, twittertest.Tweet{Text: "gucci"})
+		s.AddTweet(76395009, twittertest.Tweet{Text: "still listening to Gucci Mane"})
+		s.AddTweet(14114455, twittertest.Tweet{Text: "hello"})
+
+		if tweet := nextTweet(t, stream); tweet.Text != "still listening to Gucci Mane" || tweet.User.ScreenName != "MEMEMEMEMES" {
+			t.Errorf("Expected the tracked tweet, got %+v", tweet)
+		}
+		if tweet := nextTweet(t, stream); tweet.Text != "hello" {
+			t.Errorf("Expected the followed user's tweet, got %q", tweet.Text)
+		}
+
+		stream.Stop()
+		if _, ok := <-stream.Tweets(); ok || stream.Err() != nil {
+			t.Errorf("Stream did not stop cleanly: %v", stream.Err())
+		}
+
+		form := s.Requests()[0].Form
+		if form.Get("track") != "gucci mane,M83" || form.Get("locations") != "-122.75,36.8,-121.75,37.8" {
+			t.Errorf("Unexpected filter parameters %v", form)
+		}
+		s.Close()
+	}
+}
+
+func TestFilterStreamReconnect(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newStreamClient(s)
+
+	s.InjectError("/1.1/statuses/filter.json", twittertest.Error{Status: 503})
+	s.InjectError("/1.1/statuses/filter.json", twittertest.Error{Status: 420, Body: "Easy there, Turbo."})
+
+	stream, err := tt.FilterStream(FilterParams{Track: []string{"gucci"}})
+	if err != nil {
+		t.Fatal("Error opening stream:", err.Error())
+	}
+	defer stream.Stop()
+
+	// consume reconnect events so the stream isn't held up by them
+	stream.Tweets()
+
+	waitForStreams(t, s, 1)
+	s.DisconnectStreams()
+	waitForStreams(t, s, 1)
+
+	s.AddTweet(76395009, twittertest.Tweet{Text: "gucci"})
+	nextTweet(t, stream)
+
+	if n := len(s.Requests()); n != 4 {
+		t.Errorf("Expected 4 connection attempts, got %d", n)
+	}
+}
+
+func TestFilterStreamFatalError(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newStreamClient(s)
+	tt.OAuthTokenSecret = "wrong"
+
+	stream, err := tt.FilterStream(FilterParams{Track: []string{"gucci"}})
+	if err != nil {
+		t.Fatal("Error opening stream:", err.Error())
+	}
+
+	select {
+	case <-stream.Tweets():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream kept reconnecting after an authentication error")
+	}
+	if !IsAuthError(stream.Err()) {
+		t.Errorf("Expected an authentication error, got %v", stream.Err())
+	}
+
+	if _, err := tt.FilterStream(FilterParams{}); err == nil {
+		t.Error("Expected an error opening a stream without filters")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	newline := bufio.NewReader(strings.NewReader("{\"a\":1}\r\n\r\n{\"b\":2}\r\n"))
+	delimited := bufio.NewReader(strings.NewReader("9\r\n{\"a\":1}\r\n\r\n9\r\n{\"b\":2}\r\n"))
+
+	for name, r := range map[string]*bufio.Reader{"newline": newline, "delimited": delimited} {
+		var msgs []string
+		for {
+			msg, err := readMessage(r, r == delimited)
+			if err != nil {
+				break
+			}
+			msgs = append(msgs, string(msg))
+		}
+		if strings.Join(msgs, "|") != `{"a":1}||{"b":2}` {
+			t.Errorf("Unexpected %s messages %q", name, msgs)
+		}
+	}
+
+	huge := bufio.NewReader(strings.NewReader(fmt.Sprintf("%d\r\n{}", maxStreamMessage+1)))
+	if _, err := readMessage(huge, true); err == nil || !strings.Contains(err.Error(), "bad stream message length") {
+		t.Errorf("Expected an oversized message length to be rejected, got %v", err)
+	}
+}
+
+func TestNextDelay(t *testing.T) {
+	var delays []time.Duration
+	var d time.Duration
+	for i := 0; i < 4; i++ {
+		d = nextDelay(d, 5*time.Second, 20*time.Second)
+		delays = append(delays, d)
+	}
+
+	expected := []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 20 * time.Second}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Errorf("Expected delays %v, got %v", expected, delays)
+			break
+		}
+	}
+}
+
+func TestStreamStall(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.StreamKeepAlive = 0
+
+	tt := newStreamClient(s)
+	tt.StreamStallTimeout = 50 * time.Millisecond
+
+	stream := tt.SampleStream(SampleParams{})
+	defer stream.Stop()
+
+	var stalls []StreamStall
+	var reconnects []StreamReconnect
+	h := StreamHandlers{
+		Stall:     func(stall StreamStall) { stalls = append(stalls, stall) },
+		Reconnect: func(r StreamReconnect) { reconnects = append(reconnects, r) },
+	}
+
+	timeout := time.After(5 * time.Second)
+	for len(reconnects) < 2 {
+		select {
+		case msg := <-stream.Messages():
+			h.Handle(msg)
+		case <-timeout:
+			t.Fatal("Stalled stream was not reconnected")
+		}
+	}
+
+	if len(stalls) < 2 || stalls[0].Timeout != tt.StreamStallTimeout {
+		t.Errorf("Expected stall events, got %v", stalls)
+	}
+	if reconnects[0].Err != ErrStreamStalled || reconnects[0].Delay != fastBackoff.NetworkStep {
+		t.Errorf("Unexpected reconnect %+v", reconnects[0])
+	}
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.StreamKeepAlive = 10 * time.Millisecond
+
+	tt := newStreamClient(s)
+	tt.StreamStallTimeout = 100 * time.Millisecond
+
+	stream := tt.SampleStream(SampleParams{})
+	defer stream.Stop()
+
+	select {
+	case msg := <-stream.Messages():
+		t.Errorf("Stream kept alive was interrupted by %#v", msg)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestStreamSlowConsumer(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	s.StreamKeepAlive = 20 * time.Millisecond
+
+	tt := newStreamClient(s)
+	tt.StreamStallTimeout = 100 * time.Millisecond
+
+	stream := tt.SampleStream(SampleParams{})
+	defer stream.Stop()
+	waitForStreams(t, s, 1)
+
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+				s.AddTweet(14114455, twittertest.Tweet{Text: "busy"})
+			}
+		}
+	}()
+
+	// each tweet takes longer to handle than the stall timeout, while
+	// the server keeps sending
+	for handled := 0; handled < 4; {
+		switch msg := (<-stream.Messages()).(type) {
+		case Tweet:
+			time.Sleep(250 * time.Millisecond)
+			handled++
+		case StreamStall, StreamReconnect:
+			t.Fatalf("Slow consumer was taken for a stall: %#v", msg)
+		}
+	}
+}
+
+func TestStreamDropBackoff(t *testing.T) {
+	var tweets int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// accept the connection and drop it, with a tweet if asked
+		w.WriteHeader(http.StatusOK)
+		if atomic.LoadInt32(&tweets) == 1 {
+			fmt.Fprintln(w, `{"id": 1, "text": "hello", "user": {"id": 14114455}}`)
+		}
+	}))
+	defer srv.Close()
+
+	tt := newClient(server)
+	tt.StreamUrl = srv.URL
+	tt.StreamBackoff = &StreamBackoff{NetworkStep: 10 * time.Millisecond, NetworkMax: 30 * time.Millisecond}
+
+	delays := func(n int) (delays []time.Duration) {
+		stream := tt.SampleStream(SampleParams{})
+		defer stream.Stop()
+
+		timeout := time.After(5 * time.Second)
+		for len(delays) < n {
+			select {
+			case msg := <-stream.Messages():
+				if r, ok := msg.(StreamReconnect); ok {
+					delays = append(delays, r.Delay)
+				}
+			case <-timeout:
+				t.Fatal("Dropped stream was not reconnected")
+			}
+		}
+		return
+	}
+
+	// connections that drop without delivering anything back off
+	got := delays(4)
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected reconnect delays %v, got %v", want, got)
+	}
+
+	// ones that deliver a message start the backoff over
+	atomic.StoreInt32(&tweets, 1)
+	got = delays(3)
+	want = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected reconnect delays %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,278 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A message delivered by a stream: a Tweet or one of the notices below.
+// Unrecognised messages are delivered as UnknownMessage.
+type StreamMessage interface {
+	streamMessage()
+}
+
+func (Tweet) streamMessage()            {}
+func (StatusDeletion) streamMessage()   {}
+func (LocationDeletion) streamMessage() {}
+func (LimitNotice) streamMessage()      {}
+func (StatusWithheld) streamMessage()   {}
+func (UserWithheld) streamMessage()     {}
+func (Disconnect) streamMessage()       {}
+func (StallWarning) streamMessage()     {}
+func (Event) streamMessage()            {}
+func (UnknownMessage) streamMessage()   {}
+func (StreamStall) streamMessage()      {}
+func (StreamReconnect) streamMessage()  {}
+
+// A tweet was deleted. Stored copies of it should be removed.
+type StatusDeletion struct {
+	Id          int64
+	IdStr       string `json:"id_str"`
+	UserId      int64  `json:"user_id"`
+	UserIdStr   string `json:"user_id_str"`
+	TimestampMs string `json:"-"`
+}
+
+// A user removed the location from their tweets up to and including
+// UpToStatusId. Stored locations for them should be removed.
+type LocationDeletion struct {
+	UserId          int64  `json:"user_id"`
+	UserIdStr       string `json:"user_id_str"`
+	UpToStatusId    int64  `json:"up_to_status_id"`
+	UpToStatusIdStr string `json:"up_to_status_id_str"`
+}
+
+// More tweets matched the filter than could be delivered. Track is
+// the number undelivered since the connection was opened.
+type LimitNotice struct {
+	Track       int64
+	TimestampMs string `json:"timestamp_ms"`
+}
+
+// A tweet was withheld in the given countries
+type StatusWithheld struct {
+	Id                  int64
+	UserId              int64    `json:"user_id"`
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// A user was withheld in the given countries
+type UserWithheld struct {
+	Id                  int64
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// Codes sent with Disconnect notices
+const (
+	DisconnectShutdown        = 1
+	DisconnectDuplicateStream = 2
+	DisconnectControlRequest  = 3
+	DisconnectStall           = 4
+	DisconnectNormal          = 5
+	DisconnectTokenRevoked    = 6
+	DisconnectAdminLogout     = 7
+	DisconnectMaxMessageLimit = 9
+	DisconnectStreamException = 10
+	DisconnectBrokerStall     = 11
+	DisconnectShedLoad        = 12
+)
+
+// Twitter is about to close the connection. The stream reconnects
+// unless Fatal reports otherwise.
+type Disconnect struct {
+	Code       int
+	StreamName string `json:"stream_name"`
+	Reason     string
+}
+
+func (d Disconnect) Error() string {
+	return fmt.Sprintf("stream disconnected: %d %s", d.Code, d.Reason)
+}
+
+// Reports whether reconnecting would be pointless: the token was
+// revoked, the user logged out, or another connection took over
+func (d Disconnect) Fatal() bool {
+	switch d.Code {
+	case DisconnectDuplicateStream, DisconnectTokenRevoked, DisconnectAdminLogout:
+		return true
+	}
+	return false
+}
+
+// The client is falling behind and will be disconnected if its queue
+// fills up. Only sent when stall warnings are requested.
+type StallWarning struct {
+	Code        string
+	Message     string
+	PercentFull int `json:"percent_full"`
+}
+
+// Something happened to a user, eg. a "favorite" or "follow"
+type Event struct {
+	Event        string
+	CreatedAt    string `json:"created_at"`
+	Source       User
+	Target       User
+	TargetObject json.RawMessage `json:"target_object"`
+}
+
+// A message of a kind not listed above
+type UnknownMessage struct {
+	Raw json.RawMessage
+}
+
+// Sent by the library, rather than Twitter, when nothing arrived on a
+// connection within Timeout. It is torn down and reconnected.
+type StreamStall struct {
+	Timeout time.Duration
+}
+
+// Sent by the library, rather than Twitter, before reconnecting after
+// Delay. Err is why the previous connection ended.
+type StreamReconnect struct {
+	Err   error
+	Delay time.Duration
+}
+
+// Classifies a stream message and decodes it into its type
+func DecodeStreamMessage(b []byte) (StreamMessage, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case keys["delete"] != nil:
+		var msg = struct {
+			Status      StatusDeletion
+			TimestampMs string `json:"timestamp_ms"`
+		}{}
+		err := json.Unmarshal(keys["delete"], &msg)
+		msg.Status.TimestampMs = msg.TimestampMs
+		return msg.Status, err
+	case keys["scrub_geo"] != nil:
+		var msg LocationDeletion
+		return msg, json.Unmarshal(keys["scrub_geo"], &msg)
+	case keys["limit"] != nil:
+		var msg LimitNotice
+		return msg, json.Unmarshal(keys["limit"], &msg)
+	case keys["status_withheld"] != nil:
+		var msg StatusWithheld
+		return msg, json.Unmarshal(keys["status_withheld"], &msg)
+	case keys["user_withheld"] != nil:
+		var msg UserWithheld
+		return msg, json.Unmarshal(keys["user_withheld"], &msg)
+	case keys["disconnect"] != nil:
+		var msg Disconnect
+		return msg, json.Unmarshal(keys["disconnect"], &msg)
+	case keys["warning"] != nil:
+		var msg StallWarning
+		return msg, json.Unmarshal(keys["warning"], &msg)
+	case keys["event"] != nil:
+		var msg Event
+		return msg, json.Unmarshal(b, &msg)
+	case keys["text"] != nil && keys["id"] != nil:
+		var msg Tweet
+		return msg, json.Unmarshal(b, &msg)
+	}
+	return UnknownMessage{Raw: json.RawMessage(b)}, nil
+}
+
+// Callbacks for each kind of stream message. Messages without a
+// callback are passed to Other, if set.
+type StreamHandlers struct {
+	Tweet            func(Tweet)
+	StatusDeletion   func(StatusDeletion)
+	LocationDeletion func(LocationDeletion)
+	Limit            func(LimitNotice)
+	StatusWithheld   func(StatusWithheld)
+	UserWithheld     func(UserWithheld)
+	Disconnect       func(Disconnect)
+	StallWarning     func(StallWarning)
+	Event            func(Event)
+	Stall            func(StreamStall)
+	Reconnect        func(StreamReconnect)
+	Other            func(StreamMessage)
+}
+
+// Passes msg to its callback
+func (h *StreamHandlers) Handle(msg StreamMessage) {
+	switch m := msg.(type) {
+	case Tweet:
+		if h.Tweet != nil {
+			h.Tweet(m)
+			return
+		}
+	case StatusDeletion:
+		if h.StatusDeletion != nil {
+			h.StatusDeletion(m)
+			return
+		}
+	case LocationDeletion:
+		if h.LocationDeletion != nil {
+			h.LocationDeletion(m)
+			return
+		}
+	case LimitNotice:
+		if h.Limit != nil {
+			h.Limit(m)
+			return
+		}
+	case StatusWithheld:
+		if h.StatusWithheld != nil {
+			h.StatusWithheld(m)
+			return
+		}
+	case UserWithheld:
+		if h.UserWithheld != nil {
+			h.UserWithheld(m)
+			return
+		}
+	case Disconnect:
+		if h.Disconnect != nil {
+			h.Disconnect(m)
+			return
+		}
+	case StallWarning:
+		if h.StallWarning != nil {
+			h.StallWarning(m)
+			return
+		}
+	case Event:
+		if h.Event != nil {
+			h.Event(m)
+			return
+		}
+	case StreamStall:
+		if h.Stall != nil {
+			h.Stall(m)
+			return
+		}
+	case StreamReconnect:
+		if h.Reconnect != nil {
+			h.Reconnect(m)
+			return
+		}
+	}
+
+	if h.Other != nil {
+		h.Other(msg)
+	}
+}
@@ -0,0 +1,126 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeStreamMessage(t *testing.T) {
+	messages := map[string]StreamMessage{
+		`{"delete":{"status":{"id":1234,"id_str":"1234","user_id":3,"user_id_str":"3"},"timestamp_ms":"1361391536000"}}`: StatusDeletion{
+			Id: 1234, IdStr: "1234", UserId: 3, UserIdStr: "3", TimestampMs: "1361391536000",
+		},
+		`{"scrub_geo":{"user_id":14090452,"user_id_str":"14090452","up_to_status_id":23260136625,"up_to_status_id_str":"23260136625"}}`: LocationDeletion{
+			UserId: 14090452, UserIdStr: "14090452", UpToStatusId: 23260136625, UpToStatusIdStr: "23260136625",
+		},
+		`{"limit":{"track":1234,"timestamp_ms":"1361391536000"}}`: LimitNotice{Track: 1234, TimestampMs: "1361391536000"},
+		`{"status_withheld":{"id":1234567890,"user_id":123456,"withheld_in_countries":["DE","AR"]}}`: StatusWithheld{
+			Id: 1234567890, UserId: 123456, WithheldInCountries: []string{"DE", "AR"},
+		},
+		`{"user_withheld":{"id":123456,"withheld_in_countries":["DE"]}}`: UserWithheld{Id: 123456, WithheldInCountries: []string{"DE"}},
+		`{"disconnect":{"code":4,"stream_name":"bsdf-statuses","reason":"stalled"}}`: Disconnect{
+			Code: DisconnectStall, StreamName: "bsdf-statuses", Reason: "stalled",
+		},
+		`{"warning":{"code":"FALLING_BEHIND","message":"Your connection is falling behind.","percent_full":60}}`: StallWarning{
+			Code: "FALLING_BEHIND", Message: "Your connection is falling behind.", PercentFull: 60,
+		},
+		`{"id":221281838440783875,"text":"listening to gucci mane","user":{"screen_name":"MEMEMEMEMES"}}`: Tweet{
+			Id: 221281838440783875, Text: "listening to gucci mane", User: User{ScreenName: "MEMEMEMEMES"},
+		},
+		`{"friends":[1,2,3]}`: UnknownMessage{Raw: []byte(`{"friends":[1,2,3]}`)},
+	}
+
+	for raw, expected := range messages {
+		msg, err := DecodeStreamMessage([]byte(raw))
+		if err != nil {
+			t.Errorf("Error decoding %s: %s", raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, expected) {
+			t.Errorf("Expected %#v, got %#v", expected, msg)
+		}
+	}
+
+	msg, _ := DecodeStreamMessage([]byte(`{"event":"favorite","source":{"screen_name":"bsdf"},"target_object":{"id":1}}`))
+	if event, ok := msg.(Event); !ok || event.Event != "favorite" || event.Source.ScreenName != "bsdf" || string(event.TargetObject) != `{"id":1}` {
+		t.Errorf("Unexpected event %#v", msg)
+	}
+
+	if _, err := DecodeStreamMessage([]byte(`not json`)); err == nil {
+		t.Error("Expected an error decoding a malformed message")
+	}
+}
+
+func TestStreamHandlers(t *testing.T) {
+	var tweets, deletions, other int
+	h := StreamHandlers{
+		Tweet:          func(Tweet) { tweets++ },
+		StatusDeletion: func(StatusDeletion) { deletions++ },
+		Other:          func(StreamMessage) { other++ },
+	}
+
+	for _, msg := range []StreamMessage{Tweet{}, StatusDeletion{}, LimitNotice{}, Tweet{}, UnknownMessage{}} {
+		h.Handle(msg)
+	}
+	if tweets != 2 || deletions != 1 || other != 2 {
+		t.Errorf("Messages were not dispatched, got %d tweets, %d deletions, %d others", tweets, deletions, other)
+	}
+}
+
+func TestStreamMessages(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newStreamClient(s)
+
+	stream, err := tt.FilterStream(FilterParams{Follow: []int64{14114455}})
+	if err != nil {
+		t.Fatal("Error opening stream:", err.Error())
+	}
+	waitForStreams(t, s, 1)
+
+	s.StreamMessage(map[string]interface{}{"limit": map[string]interface{}{"track": 12}})
+	s.StreamMessage(map[string]interface{}{
+		"disconnect": map[string]interface{}{"code": DisconnectTokenRevoked, "reason": "token revoked"},
+	})
+	s.DisconnectStreams()
+
+	var limits []LimitNotice
+	var disconnects []Disconnect
+	done := make(chan error)
+	go func() {
+		done <- stream.Handle(StreamHandlers{
+			Limit:      func(l LimitNotice) { limits = append(limits, l) },
+			Disconnect: func(d Disconnect) { disconnects = append(disconnects, d) },
+		})
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream reconnected after its token was revoked")
+	}
+
+	if len(limits) != 1 || limits[0].Track != 12 {
+		t.Errorf("Expected a limit notice, got %v", limits)
+	}
+	if d, ok := err.(Disconnect); !ok || d.Code != DisconnectTokenRevoked || len(disconnects) != 1 {
+		t.Errorf("Expected the stream to stop with the disconnect, got %v", err)
+	}
+}
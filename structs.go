@@ -90,15 +90,33 @@ type User struct {
 }
 
 type SearchResult struct {
-	Query   string
-	Results []Tweet `json:"statuses"`
+	Query    string
+	Results  []Tweet        `json:"statuses"`
+	Metadata SearchMetadata `json:"search_metadata"`
+}
+
+type SearchMetadata struct {
+	Query       string
+	Count       int
+	CompletedIn float64 `json:"completed_in"`
+	MaxId       int64   `json:"max_id"`
+	SinceId     int64   `json:"since_id"`
+	// Query string for the next, older page of results, if any
+	NextResults string `json:"next_results"`
+	// Query string for results newer than these
+	RefreshUrl string `json:"refresh_url"`
 }
 
 type RateLimitStatus struct {
-	RemainingHits    int    `json:"remaining_hits"`
-	ResetTime        string `json:"reset_time"`
-	ResetTimeSeconds int64  `json:"reset_time_in_seconds"`
-	HourlyLimit      int    `json:"hourly_limit"`
+	Context RateLimitContext `json:"rate_limit_context"`
+	// Rate limits keyed by family, then endpoint, eg.
+	// Resources["statuses"]["/statuses/user_timeline"]
+	Resources map[string]map[string]RateLimit
+}
+
+type RateLimitContext struct {
+	AccessToken string `json:"access_token"`
+	Application string
 }
 
 type DirectMessage struct {
@@ -0,0 +1,292 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+)
+
+const (
+	// Twitter only serves the most recent tweets of a user's timeline
+	maxTimelineTweets = 3200
+
+	// Tweets per page when Count isn't set, and the most Twitter allows
+	defaultTimelineCount = 20
+	maxTimelineCount     = 200
+)
+
+// Selects whose timeline to walk and how. Set either UserId or
+// ScreenName.
+type TimelineParams struct {
+	UserId     int64
+	ScreenName string
+
+	// Tweets per page, at most 200. Twitter's default is used if zero.
+	Count int
+
+	// Only walk tweets newer than SinceId and no newer than MaxId
+	SinceId int64
+	MaxId   int64
+
+	ExcludeReplies  bool
+	ExcludeRetweets bool
+
+	// Only include the id of each tweet's author
+	TrimUser bool
+
+	// Walk forwards from SinceId, oldest tweet first, rather than
+	// backwards from the newest
+	Forward bool
+}
+
+// Walks a user's timeline a page at a time from newest to oldest,
+// following max_id until it runs out or reaches the 3,200 tweets Twitter
+// serves. Twitter filters replies and retweets after choosing a page,
+// so pages may be short when they are excluded. Pages left empty by
+// filtering are skipped rather than ending the walk.
+//
+// Twitter only serves timelines newest first, so a forward walk first
+// fetches every tweet newer than SinceId the same way, then returns
+// them a page at a time from the oldest.
+type TimelineIterator struct {
+	t      *Twitter
+	ctx    context.Context
+	params TimelineParams
+
+	tweets []Tweet
+	newest int64
+	walked int
+	done   bool
+	err    error
+
+	// tweets fetched by a forward walk but not yet returned, oldest
+	// first
+	pending   []Tweet
+	collected bool
+}
+
+// Walks a user's timeline backwards, or forwards from SinceId if
+// Forward is set. To sync incrementally, pass the previous walk's
+// Newest as SinceId to only see tweets posted since.
+func (t *Twitter) UserTimeline(params TimelineParams) *TimelineIterator {
+	return t.UserTimelineContext(context.Background(), params)
+}
+
+// UserTimeline with a context for cancellation and deadlines
+func (t *Twitter) UserTimelineContext(ctx context.Context, params TimelineParams) *TimelineIterator {
+	return &TimelineIterator{t: t, ctx: ctx, params: params, newest: params.SinceId}
+}
+
+// Fetches the next page, returning false once there are no more tweets
+// or a request fails
+func (it *TimelineIterator) Next() bool {
+	if it.params.Forward {
+		return it.nextForward()
+	}
+
+	tweets, ok := it.nextPage()
+	if !ok {
+		return false
+	}
+
+	it.tweets = tweets
+	for _, tweet := range tweets {
+		if tweet.Id > it.newest {
+			it.newest = tweet.Id
+		}
+	}
+	return true
+}
+
+// Returns the next page of a forward walk, fetching every new tweet
+// the first time it is called
+func (it *TimelineIterator) nextForward() bool {
+	if !it.collected {
+		it.collected = true
+		for {
+			tweets, ok := it.nextPage()
+			if !ok {
+				break
+			}
+			it.pending = append(it.pending, tweets...)
+		}
+		if it.err != nil {
+			return false
+		}
+
+		for i, j := 0, len(it.pending)-1; i < j; i, j = i+1, j-1 {
+			it.pending[i], it.pending[j] = it.pending[j], it.pending[i]
+		}
+	}
+
+	if len(it.pending) == 0 {
+		return false
+	}
+
+	n := it.params.pageSize()
+	if n > len(it.pending) {
+		n = len(it.pending)
+	}
+	it.tweets = it.pending[:n:n]
+	it.pending = it.pending[n:]
+	it.newest = it.tweets[n-1].Id
+	return true
+}
+
+// Fetches the next page walking backwards, skipping pages emptied by
+// filtering
+func (it *TimelineIterator) nextPage() ([]Tweet, bool) {
+	filtered := it.params.ExcludeReplies || it.params.ExcludeRetweets
+
+	for !it.done && it.err == nil && it.walked < maxTimelineTweets {
+		tweets, err := it.fetch(it.params)
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+
+		if len(tweets) > 0 {
+			// Twitter counts a page before filtering it, so a filtered
+			// page walked a full page of the timeline
+			if filtered {
+				it.walked += it.params.pageSize()
+			} else {
+				it.walked += len(tweets)
+			}
+			it.advance(tweets)
+			return tweets, true
+		}
+
+		if !filtered {
+			it.done = true
+			return nil, false
+		}
+
+		// every tweet on the page was filtered out, which doesn't mean
+		// the timeline has ended. Fetch the page unfiltered to find
+		// where it ended and carry on from there.
+		unfiltered := it.params
+		unfiltered.ExcludeReplies = false
+		unfiltered.ExcludeRetweets = false
+		unfiltered.TrimUser = true
+
+		skipped, err := it.fetch(unfiltered)
+		if err != nil {
+			it.err = err
+			return nil, false
+		}
+		if len(skipped) == 0 {
+			it.done = true
+			return nil, false
+		}
+		it.walked += len(skipped)
+		it.advance(skipped)
+	}
+	return nil, false
+}
+
+// Requests a page of the timeline
+func (it *TimelineIterator) fetch(params TimelineParams) (tweets []Tweet, err error) {
+	method := &RestMethod{
+		Url:    it.t.apiUrl("statuses/user_timeline.json?" + queryString(params.values())),
+		Method: "GET",
+		auth:   appAuth,
+	}
+
+	body, err := it.t.sendRestRequest(it.ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &tweets)
+	return
+}
+
+// Moves max_id below the oldest of tweets
+func (it *TimelineIterator) advance(tweets []Tweet) {
+	for _, tweet := range tweets {
+		if it.params.MaxId == 0 || tweet.Id <= it.params.MaxId {
+			it.params.MaxId = tweet.Id - 1
+		}
+	}
+}
+
+// Returns the tweets on the current page, newest first, or oldest
+// first when walking forward
+func (it *TimelineIterator) Tweets() []Tweet {
+	return it.tweets
+}
+
+// Returns the id of the newest tweet seen, or SinceId if there were
+// none. Use it as the SinceId of the next sync once the walk is done;
+// when walking forward it may also be saved after every page.
+func (it *TimelineIterator) Newest() int64 {
+	return it.newest
+}
+
+// Returns the max_id that continues a backward walk after the current
+// page
+func (it *TimelineIterator) MaxId() int64 {
+	return it.params.MaxId
+}
+
+// Returns the error that stopped the walk, if any
+func (it *TimelineIterator) Err() error {
+	return it.err
+}
+
+// Returns how many tweets of the timeline a page covers
+func (p TimelineParams) pageSize() int {
+	switch {
+	case p.Count <= 0:
+		return defaultTimelineCount
+	case p.Count > maxTimelineCount:
+		return maxTimelineCount
+	}
+	return p.Count
+}
+
+func (p TimelineParams) values() url.Values {
+	v := url.Values{}
+	if p.UserId != 0 {
+		v.Set("user_id", strconv.FormatInt(p.UserId, 10))
+	} else {
+		v.Set("screen_name", p.ScreenName)
+	}
+	if p.Count > 0 {
+		v.Set("count", strconv.Itoa(p.Count))
+	}
+	if p.SinceId > 0 {
+		v.Set("since_id", strconv.FormatInt(p.SinceId, 10))
+	}
+	if p.MaxId > 0 {
+		v.Set("max_id", strconv.FormatInt(p.MaxId, 10))
+	}
+	if p.ExcludeReplies {
+		v.Set("exclude_replies", "true")
+	}
+	if p.ExcludeRetweets {
+		v.Set("include_rts", "false")
+	}
+	if p.TrimUser {
+		v.Set("trim_user", "true")
+	}
+	return v
+}
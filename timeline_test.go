@@ -0,0 +1,206 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/bsdf/twitter/twittertest"
+)
+
+// Starts a fake server where @archivist has tweeted 25 times, including
+// a reply and a retweet
+func newTimelineServer() (*Twitter, *twittertest.Server) {
+	s := newTestServer()
+	u := s.AddUser(twittertest.User{ScreenName: "archivist"})
+	for i := 0; i < 23; i++ {
+		s.AddTweet(u.Id, twittertest.Tweet{Text: "tweet"})
+	}
+	s.AddTweet(u.Id, twittertest.Tweet{Text: "@bsdf reply", InReplyToStatusId: 221281838440783875})
+	s.AddTweet(u.Id, twittertest.Tweet{
+		Text:            "RT @MEMEMEMEMES: listening to gucci mane",
+		RetweetedStatus: &twittertest.Tweet{Id: 221281838440783875},
+	})
+
+	return newClient(s), s
+}
+
+func walkTimeline(t *testing.T, it *TimelineIterator) (tweets []Tweet, pages int) {
+	for it.Next() {
+		pages++
+		tweets = append(tweets, it.Tweets()...)
+	}
+	if it.Err() != nil {
+		t.Fatal("Error walking timeline:", it.Err())
+	}
+	return
+}
+
+func TestTimelineIterator(t *testing.T) {
+	tt, s := newTimelineServer()
+	defer s.Close()
+
+	tweets, pages := walkTimeline(t, tt.UserTimeline(TimelineParams{ScreenName: "archivist", Count: 10}))
+	if len(tweets) != 25 || pages != 3 {
+		t.Fatalf("Expected 25 tweets over 3 pages, got %d over %d", len(tweets), pages)
+	}
+	for i := 1; i < len(tweets); i++ {
+		if tweets[i].Id >= tweets[i-1].Id {
+			t.Fatal("Timeline was not walked newest to oldest")
+		}
+	}
+
+	filtered, _ := walkTimeline(t, tt.UserTimeline(TimelineParams{
+		ScreenName:      "archivist",
+		ExcludeReplies:  true,
+		ExcludeRetweets: true,
+		TrimUser:        true,
+	}))
+	if len(filtered) != 23 {
+		t.Errorf("Expected 23 tweets without replies or retweets, got %d", len(filtered))
+	}
+	if filtered[0].User.ScreenName != "" {
+		t.Error("User was not trimmed")
+	}
+
+	// the newest page holds only the retweet and the reply, so it is
+	// empty once they are filtered out
+	filtered, _ = walkTimeline(t, tt.UserTimeline(TimelineParams{
+		ScreenName:      "archivist",
+		Count:           2,
+		ExcludeReplies:  true,
+		ExcludeRetweets: true,
+	}))
+	if len(filtered) != 23 {
+		t.Errorf("Expected 23 tweets past an empty filtered page, got %d", len(filtered))
+	}
+}
+
+func TestTimelineSince(t *testing.T) {
+	tt, s := newTimelineServer()
+	defer s.Close()
+
+	it := tt.UserTimeline(TimelineParams{ScreenName: "archivist"})
+	walkTimeline(t, it)
+	newest := it.Newest()
+
+	u, _ := tt.GetUser("archivist")
+	s.AddTweet(u.Id, twittertest.Tweet{Text: "new"})
+	s.AddTweet(u.Id, twittertest.Tweet{Text: "newer"})
+
+	it = tt.UserTimeline(TimelineParams{UserId: u.Id, SinceId: newest, Count: 1})
+	tweets, _ := walkTimeline(t, it)
+	if len(tweets) != 2 || tweets[0].Text != "newer" || tweets[1].Text != "new" {
+		t.Errorf("Expected the 2 new tweets, got %v", tweets)
+	}
+	if it.Newest() != tweets[0].Id {
+		t.Errorf("Expected newest %d, got %d", tweets[0].Id, it.Newest())
+	}
+
+	it = tt.UserTimeline(TimelineParams{UserId: u.Id, SinceId: it.Newest()})
+	if tweets, _ := walkTimeline(t, it); len(tweets) != 0 || it.Newest() == 0 {
+		t.Errorf("Expected nothing new, got %d tweets", len(tweets))
+	}
+}
+
+func TestTimelineForward(t *testing.T) {
+	tt, s := newTimelineServer()
+	defer s.Close()
+
+	it := tt.UserTimeline(TimelineParams{ScreenName: "archivist"})
+	walkTimeline(t, it)
+	since := it.Newest()
+
+	u, _ := tt.GetUser("archivist")
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		s.AddTweet(u.Id, twittertest.Tweet{Text: text})
+	}
+
+	it = tt.UserTimeline(TimelineParams{UserId: u.Id, SinceId: since, Count: 2, Forward: true})
+	var texts []string
+	var sizes []int
+	for it.Next() {
+		page := it.Tweets()
+		sizes = append(sizes, len(page))
+		for _, tweet := range page {
+			texts = append(texts, tweet.Text)
+		}
+		if last := page[len(page)-1].Id; it.Newest() != last {
+			t.Errorf("Expected newest %d after a page, got %d", last, it.Newest())
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal("Error walking timeline forward:", it.Err())
+	}
+
+	if !reflect.DeepEqual(texts, []string{"one", "two", "three", "four", "five"}) {
+		t.Errorf("Expected the new tweets oldest first, got %v", texts)
+	}
+	if !reflect.DeepEqual(sizes, []int{2, 2, 1}) {
+		t.Errorf("Expected pages of 2, 2 and 1 tweets, got %v", sizes)
+	}
+
+	it = tt.UserTimeline(TimelineParams{UserId: u.Id, SinceId: it.Newest(), Forward: true})
+	if tweets, _ := walkTimeline(t, it); len(tweets) != 0 {
+		t.Errorf("Expected nothing new, got %d tweets", len(tweets))
+	}
+}
+
+func TestTimelineLimit(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+
+	// every other tweet is a reply, and the oldest 100 are past the
+	// 3,200 Twitter serves
+	u := s.AddUser(twittertest.User{ScreenName: "prolific"})
+	for i := 0; i < maxTimelineTweets+100; i++ {
+		tweet := twittertest.Tweet{Text: fmt.Sprint("tweet ", i)}
+		if i%2 == 1 {
+			tweet.InReplyToStatusId = 221281838440783875
+		}
+		s.AddTweet(u.Id, tweet)
+	}
+
+	countPages := func() (n int) {
+		for _, r := range s.Requests() {
+			if r.Path == "/1.1/statuses/user_timeline.json" {
+				n++
+			}
+		}
+		return
+	}
+
+	tweets, _ := walkTimeline(t, tt.UserTimeline(TimelineParams{UserId: u.Id, Count: 200}))
+	if len(tweets) != maxTimelineTweets {
+		t.Errorf("Expected %d tweets, got %d", maxTimelineTweets, len(tweets))
+	}
+	if n := countPages(); n != 16 {
+		t.Errorf("Expected 16 requests, got %d", n)
+	}
+
+	before := countPages()
+	tweets, _ = walkTimeline(t, tt.UserTimeline(TimelineParams{UserId: u.Id, Count: 200, ExcludeReplies: true}))
+	if len(tweets) != maxTimelineTweets/2 {
+		t.Errorf("Expected %d tweets without replies, got %d", maxTimelineTweets/2, len(tweets))
+	}
+	if n := countPages() - before; n != 16 {
+		t.Errorf("Expected 16 requests with replies excluded, got %d", n)
+	}
+}
@@ -17,39 +17,173 @@
 package twitter
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Hosts used when the corresponding Twitter field is empty
+const (
+	DefaultApiUrl    = "https://api.twitter.com/1.1"
+	DefaultOAuthUrl  = "https://api.twitter.com"
+	DefaultUploadUrl = "https://upload.twitter.com/1.1"
+	DefaultStreamUrl = "https://stream.twitter.com/1.1"
+
+	// Where users approve OAuth 2.0 authorization requests
+	DefaultOAuth2AuthorizeUrl = "https://twitter.com/i/oauth2/authorize"
+)
+
+// A client for the Twitter API. Its fields configure it and may be set
+// directly, but a Twitter must not be copied once it is in use: it
+// holds locks and per-endpoint rate limits that a copy would not share.
+// Use WithToken to derive a client for another user.
 type Twitter struct {
 	ConsumerKey      string
 	ConsumerSecret   string
 	OAuthToken       string
 	OAuthTokenSecret string
 	DebugMode        bool
+
+	// Client used for every request, including the OAuth handshake.
+	// Defaults to http.DefaultClient when nil. Set its Transport to
+	// configure proxies, TLS or a test RoundTripper.
+	HttpClient *http.Client
+
+	// Base URLs for the REST API, the OAuth endpoints, media uploads
+	// and streaming. Point these at a local server to run without
+	// network access. Empty values fall back to the Default*Url hosts.
+	ApiUrl    string
+	OAuthUrl  string
+	UploadUrl string
+	StreamUrl string
+
+	// Use application-only authentication for read-only endpoints that
+	// support it, which are rate limited separately from the user. A
+	// bearer token is fetched with the consumer credentials on first use.
+	AppAuth bool
+
+	// Cached application-only bearer token. May be set ahead of time
+	// to skip fetching one.
+	BearerToken string
+
+	// OAuth 2.0 client credentials for the authorization code flow.
+	// ClientSecret is left empty for public clients.
+	ClientId     string
+	ClientSecret string
+
+	// Page AuthorizeCodeUrl sends users to. Falls back to
+	// DefaultOAuth2AuthorizeUrl when empty.
+	OAuth2AuthorizeUrl string
+
+	// OAuth 2.0 user token. When set, requests are sent with it rather
+	// than signed with OAuthToken, and it is refreshed once it expires.
+	OAuth2Token *OAuth2Token
+
+	// Called with the new token after an automatic refresh so it can
+	// be persisted
+	OnTokenRefresh func(OAuth2Token)
+
+	// Wait for the window to reset, rather than failing, when a request
+	// is rate limited or its endpoint's tracked limit is exhausted. A
+	// request is re-sent at most three times after being rate limited.
+	WaitOnRateLimit bool
+
+	// Called before waiting out a rate limit with the endpoint and its
+	// limit. Returning an error fails the request with it instead.
+	OnRateLimit func(endpoint string, limit RateLimit) error
+
+	// Extra time waited past a rate limit's reset, and the least time
+	// waited before re-sending when the reset has already passed.
+	// DefaultRateLimitSlack is used if zero.
+	RateLimitSlack time.Duration
+
+	// How failed requests are retried. Nothing is retried if nil.
+	Retry *RetryPolicy
+
+	// Delays between stream reconnects. DefaultStreamBackoff is used
+	// if nil.
+	StreamBackoff *StreamBackoff
+
+	// How long a stream may be silent before it is reconnected.
+	// DefaultStallTimeout is used if zero.
+	StreamStallTimeout time.Duration
+
+	// Shortest wait between checks on uploaded media that is still
+	// processing. DefaultMediaCheckInterval is used if zero.
+	MediaCheckInterval time.Duration
+
+	bearerMu   sync.Mutex
+	oauth2Mu   sync.Mutex
+	rateMu     sync.Mutex
+	rateLimits map[string]RateLimit
 }
 
 func New(consumerKey, consumerSecret, oauthToken, oauthTokenSecret string) *Twitter {
+	return NewWithClient(consumerKey, consumerSecret, oauthToken, oauthTokenSecret, nil)
+}
+
+// Creates a Twitter that sends all of its requests through client
+func NewWithClient(consumerKey, consumerSecret, oauthToken, oauthTokenSecret string, client *http.Client) *Twitter {
 	return &Twitter{
-		consumerKey,
-		consumerSecret,
-		oauthToken,
-		oauthTokenSecret,
-		false,
+		ConsumerKey:      consumerKey,
+		ConsumerSecret:   consumerSecret,
+		OAuthToken:       oauthToken,
+		OAuthTokenSecret: oauthTokenSecret,
+		HttpClient:       client,
+	}
+}
+
+// Returns a new client with t's configuration, acting with the given
+// access token. Rate limits are tracked separately from t's.
+func (t *Twitter) WithToken(oauthToken, oauthTokenSecret string) *Twitter {
+	return &Twitter{
+		ConsumerKey:        t.ConsumerKey,
+		ConsumerSecret:     t.ConsumerSecret,
+		OAuthToken:         oauthToken,
+		OAuthTokenSecret:   oauthTokenSecret,
+		DebugMode:          t.DebugMode,
+		HttpClient:         t.HttpClient,
+		ApiUrl:             t.ApiUrl,
+		OAuthUrl:           t.OAuthUrl,
+		UploadUrl:          t.UploadUrl,
+		StreamUrl:          t.StreamUrl,
+		AppAuth:            t.AppAuth,
+		BearerToken:        t.currentBearerToken(),
+		ClientId:           t.ClientId,
+		ClientSecret:       t.ClientSecret,
+		OAuth2AuthorizeUrl: t.OAuth2AuthorizeUrl,
+		WaitOnRateLimit:    t.WaitOnRateLimit,
+		OnRateLimit:        t.OnRateLimit,
+		RateLimitSlack:     t.RateLimitSlack,
+		Retry:              t.Retry,
+		StreamBackoff:      t.StreamBackoff,
+		StreamStallTimeout: t.StreamStallTimeout,
+		MediaCheckInterval: t.MediaCheckInterval,
 	}
 }
 
 // Retrieves a user's timeline
 func (t *Twitter) GetUserTimeline(screenName string) (tweets []Tweet, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/statuses/user_timeline.json?screen_name=%s", screenName)
+	return t.GetUserTimelineContext(context.Background(), screenName)
+}
+
+// GetUserTimeline with a context for cancellation and deadlines
+func (t *Twitter) GetUserTimelineContext(ctx context.Context, screenName string) (tweets []Tweet, err error) {
+	url := t.apiUrl("statuses/user_timeline.json?" + queryString(url.Values{"screen_name": {screenName}}))
 	method := &RestMethod{
 		Url:    url,
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -61,15 +195,52 @@ func (t *Twitter) GetUserTimeline(screenName string) (tweets []Tweet, err error)
 // Send a tweet
 // Returns the Tweet if successful, error if unsuccessful
 func (t *Twitter) Tweet(message string) (tweet Tweet, err error) {
+	return t.TweetContext(context.Background(), message)
+}
+
+// Tweet with a context for cancellation and deadlines
+func (t *Twitter) TweetContext(ctx context.Context, message string) (tweet Tweet, err error) {
 	data := fmt.Sprintf("status=%s", encode(message))
 
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/statuses/update.json",
+		Url:    t.apiUrl("statuses/update.json"),
 		Method: "POST",
 		Data:   data,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(body, &tweet)
+	return
+}
+
+// Tweets message with up to four images, a GIF or a video attached,
+// given the ids of uploaded media
+func (t *Twitter) TweetWithMedia(message string, mediaIds ...int64) (tweet Tweet, err error) {
+	return t.TweetWithMediaContext(context.Background(), message, mediaIds...)
+}
+
+// TweetWithMedia with a context for cancellation and deadlines
+func (t *Twitter) TweetWithMediaContext(ctx context.Context, message string, mediaIds ...int64) (tweet Tweet, err error) {
+	ids := make([]string, len(mediaIds))
+	for i, id := range mediaIds {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+
+	v := url.Values{}
+	v.Set("status", message)
+	v.Set("media_ids", strings.Join(ids, ","))
+
+	method := &RestMethod{
+		Url:    t.apiUrl("statuses/update.json"),
+		Method: "POST",
+		Data:   queryString(v),
+	}
+
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -81,13 +252,18 @@ func (t *Twitter) Tweet(message string) (tweet Tweet, err error) {
 // Follow a user
 // Returns the User if successful, error if unsuccessful
 func (t *Twitter) Follow(username string) (user User, err error) {
+	return t.FollowContext(context.Background(), username)
+}
+
+// Follow with a context for cancellation and deadlines
+func (t *Twitter) FollowContext(ctx context.Context, username string) (user User, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/friendships/create.json",
+		Url:    t.apiUrl("friendships/create.json"),
 		Method: "POST",
 		Data:   fmt.Sprintf("screen_name=%s", encode(username)),
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -99,13 +275,18 @@ func (t *Twitter) Follow(username string) (user User, err error) {
 // Unfollow a user
 // Returns the User if successful, error if unsuccessful
 func (t *Twitter) Unfollow(username string) (user User, err error) {
+	return t.UnfollowContext(context.Background(), username)
+}
+
+// Unfollow with a context for cancellation and deadlines
+func (t *Twitter) UnfollowContext(ctx context.Context, username string) (user User, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/friendships/destroy.json",
+		Url:    t.apiUrl("friendships/destroy.json"),
 		Method: "POST",
 		Data:   fmt.Sprintf("screen_name=%s", encode(username)),
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -117,14 +298,19 @@ func (t *Twitter) Unfollow(username string) (user User, err error) {
 // Retweets a tweet based upon its id
 // Returns the Tweet if successful, error if unsuccessful
 func (t *Twitter) Retweet(id int64) (tweet Tweet, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/statuses/retweet/%d.json", id)
+	return t.RetweetContext(context.Background(), id)
+}
+
+// Retweet with a context for cancellation and deadlines
+func (t *Twitter) RetweetContext(ctx context.Context, id int64) (tweet Tweet, err error) {
+	url := t.apiUrl(fmt.Sprintf("statuses/retweet/%d.json", id))
 
 	method := &RestMethod{
 		Url:    url,
 		Method: "POST",
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -136,14 +322,19 @@ func (t *Twitter) Retweet(id int64) (tweet Tweet, err error) {
 // Destroys a tweet based upon its id
 // Returns the Tweet if successful, error if unsuccessful
 func (t *Twitter) Destroy(id int64) (tweet Tweet, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/statuses/destroy/%d.json", id)
+	return t.DestroyContext(context.Background(), id)
+}
+
+// Destroy with a context for cancellation and deadlines
+func (t *Twitter) DestroyContext(ctx context.Context, id int64) (tweet Tweet, err error) {
+	url := t.apiUrl(fmt.Sprintf("statuses/destroy/%d.json", id))
 
 	method := &RestMethod{
 		Url:    url,
 		Method: "POST",
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -152,53 +343,76 @@ func (t *Twitter) Destroy(id int64) (tweet Tweet, err error) {
 	return
 }
 
-// Destroys a tweet based upon its id
-// Returns the Tweet if successful, error if unsuccessful
+// Searches recent tweets for query
+// Returns the matching Tweets if successful, error if unsuccessful
 func (t *Twitter) Search(query string) (tweets []Tweet, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/search/tweets.json?q=%s", encode(query))
+	return t.SearchContext(context.Background(), query)
+}
+
+// Search with a context for cancellation and deadlines
+func (t *Twitter) SearchContext(ctx context.Context, query string) (tweets []Tweet, err error) {
+	result, err := t.SearchWithParamsContext(ctx, SearchParams{Query: query})
+	return result.Results, err
+}
+
+// Returns the current rate limits for the client's token. If any
+// families are given, eg. "statuses" or "search", only those are
+// returned.
+func (t *Twitter) GetRateLimitStatus(families ...string) (status RateLimitStatus, err error) {
+	return t.GetRateLimitStatusContext(context.Background(), families...)
+}
+
+// GetRateLimitStatus with a context for cancellation and deadlines
+func (t *Twitter) GetRateLimitStatusContext(ctx context.Context, families ...string) (status RateLimitStatus, err error) {
+	url := t.apiUrl("application/rate_limit_status.json")
+	if len(families) > 0 {
+		url += "?resources=" + encode(strings.Join(families, ","))
+	}
 
 	method := &RestMethod{
 		Url:    url,
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
 
-	var result SearchResult
-	err = json.Unmarshal(body, &result)
+	err = json.Unmarshal(body, &status)
 	if err != nil {
 		return
 	}
 
-	return result.Results, err
-}
-
-// Returns current RateLimitStatus or error
-// func (t *Twitter) GetRateLimitStatus() (status RateLimitStatus, err error) {
-// 	method := &RestMethod{
-// 		Url:    "https://api.twitter.com/1.1/application/rate_limit_status.json",
-// 		Method: "GET",
-// 	}
-
-// 	body, err := t.sendRestRequest(method)
-// 	if err != nil {
-// 		return
-// 	}
+	// keep the tracked limits up to date
+	t.rateMu.Lock()
+	defer t.rateMu.Unlock()
 
-// 	err = json.Unmarshal(body, &status)
-// 	return
-// }
+	if t.rateLimits == nil {
+		t.rateLimits = make(map[string]RateLimit)
+	}
+	for _, endpoints := range status.Resources {
+		for endpoint, limit := range endpoints {
+			t.rateLimits[endpoint] = limit
+		}
+	}
+	return
+}
 
 func (t *Twitter) GetPrivacyPolicy() (policy string, err error) {
+	return t.GetPrivacyPolicyContext(context.Background())
+}
+
+// GetPrivacyPolicy with a context for cancellation and deadlines
+func (t *Twitter) GetPrivacyPolicyContext(ctx context.Context) (policy string, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/help/privacy.json",
+		Url:    t.apiUrl("help/privacy.json"),
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -216,12 +430,18 @@ func (t *Twitter) GetPrivacyPolicy() (policy string, err error) {
 }
 
 func (t *Twitter) GetTOS() (tos string, err error) {
+	return t.GetTOSContext(context.Background())
+}
+
+// GetTOS with a context for cancellation and deadlines
+func (t *Twitter) GetTOSContext(ctx context.Context) (tos string, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/help/tos.json",
+		Url:    t.apiUrl("help/tos.json"),
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -239,30 +459,24 @@ func (t *Twitter) GetTOS() (tos string, err error) {
 }
 
 func (t *Twitter) GetUserFriends(user string) (friends []int64, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/friends/ids.json?screen_name=%s", user)
-	method := &RestMethod{
-		Url:    url,
-		Method: "GET",
-	}
-
-	body, err := t.sendRestRequest(method)
-	if err != nil {
-		return
-	}
-
-	var responseStruct = struct {
-		Ids []int64
-	}{}
+	return t.GetUserFriendsContext(context.Background(), user)
+}
 
-	err = json.Unmarshal(body, &responseStruct)
-	if err != nil {
-		return
+// GetUserFriends with a context for cancellation and deadlines
+func (t *Twitter) GetUserFriendsContext(ctx context.Context, user string) (friends []int64, err error) {
+	it := t.FriendIdsContext(ctx, IdsParams{ScreenName: user})
+	for it.Next() {
+		friends = append(friends, it.Ids()...)
 	}
-
-	return responseStruct.Ids, err
+	return friends, it.Err()
 }
 
 func (t *Twitter) LookupUsersById(ids []int64) (users []User, err error) {
+	return t.LookupUsersByIdContext(context.Background(), ids)
+}
+
+// LookupUsersById with a context for cancellation and deadlines
+func (t *Twitter) LookupUsersByIdContext(ctx context.Context, ids []int64) (users []User, err error) {
 	if len(ids) > 100 {
 		return users, errors.New("LookupUsersById can only take 100 or less ids")
 	}
@@ -274,14 +488,15 @@ func (t *Twitter) LookupUsersById(ids []int64) (users []User, err error) {
 		i++
 	}
 
-	urlBase := "https://api.twitter.com/1.1/users/lookup.json?include_entities=false&user_id=%s"
-	url := fmt.Sprintf(urlBase, encode(strings.Join(strIds, ",")))
+	urlBase := "users/lookup.json?include_entities=false&user_id=%s"
+	url := t.apiUrl(fmt.Sprintf(urlBase, encode(strings.Join(strIds, ","))))
 	method := &RestMethod{
 		Url:    url,
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -291,12 +506,17 @@ func (t *Twitter) LookupUsersById(ids []int64) (users []User, err error) {
 }
 
 func (t *Twitter) GetDirectMessages() (dms []DirectMessage, err error) {
+	return t.GetDirectMessagesContext(context.Background())
+}
+
+// GetDirectMessages with a context for cancellation and deadlines
+func (t *Twitter) GetDirectMessagesContext(ctx context.Context) (dms []DirectMessage, err error) {
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/direct_messages.json",
+		Url:    t.apiUrl("direct_messages.json"),
 		Method: "GET",
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -306,14 +526,19 @@ func (t *Twitter) GetDirectMessages() (dms []DirectMessage, err error) {
 }
 
 func (t *Twitter) SendDirectMessage(user, text string) (dm DirectMessage, err error) {
+	return t.SendDirectMessageContext(context.Background(), user, text)
+}
+
+// SendDirectMessage with a context for cancellation and deadlines
+func (t *Twitter) SendDirectMessageContext(ctx context.Context, user, text string) (dm DirectMessage, err error) {
 	data := fmt.Sprintf("screen_name=%s&text=%s", encode(user), encode(text))
 	method := &RestMethod{
-		Url:    "https://api.twitter.com/1.1/direct_messages/new.json",
+		Url:    t.apiUrl("direct_messages/new.json"),
 		Method: "POST",
 		Data:   data,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -323,13 +548,18 @@ func (t *Twitter) SendDirectMessage(user, text string) (dm DirectMessage, err er
 }
 
 func (t *Twitter) DeleteDirectMessage(id int64) (dm DirectMessage, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/direct_messages/destroy/%d.json", id)
+	return t.DeleteDirectMessageContext(context.Background(), id)
+}
+
+// DeleteDirectMessage with a context for cancellation and deadlines
+func (t *Twitter) DeleteDirectMessageContext(ctx context.Context, id int64) (dm DirectMessage, err error) {
+	url := t.apiUrl(fmt.Sprintf("direct_messages/destroy/%d.json", id))
 	method := &RestMethod{
 		Url:    url,
 		Method: "POST",
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -339,13 +569,19 @@ func (t *Twitter) DeleteDirectMessage(id int64) (dm DirectMessage, err error) {
 }
 
 func (t *Twitter) GetUser(userName string) (user User, err error) {
-	url := fmt.Sprintf("https://api.twitter.com/1.1/users/show.json?screen_name=%s", userName)
+	return t.GetUserContext(context.Background(), userName)
+}
+
+// GetUser with a context for cancellation and deadlines
+func (t *Twitter) GetUserContext(ctx context.Context, userName string) (user User, err error) {
+	url := t.apiUrl("users/show.json?" + queryString(url.Values{"screen_name": {userName}}))
 	method := &RestMethod{
 		Url:    url,
 		Method: "GET",
+		auth:   appAuth,
 	}
 
-	body, err := t.sendRestRequest(method)
+	body, err := t.sendRestRequest(ctx, method)
 	if err != nil {
 		return
 	}
@@ -353,3 +589,31 @@ func (t *Twitter) GetUser(userName string) (user User, err error) {
 	err = json.Unmarshal(body, &user)
 	return
 }
+
+// Joins path onto base, or onto def when base is empty
+func joinUrl(base, def, path string) string {
+	if base == "" {
+		base = def
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+// Returns the REST API url for path
+func (t *Twitter) apiUrl(path string) string {
+	return joinUrl(t.ApiUrl, DefaultApiUrl, path)
+}
+
+// Returns the OAuth url for path
+func (t *Twitter) oauthUrl(path string) string {
+	return joinUrl(t.OAuthUrl, DefaultOAuthUrl, path)
+}
+
+// Returns the media upload url for path
+func (t *Twitter) uploadUrl(path string) string {
+	return joinUrl(t.UploadUrl, DefaultUploadUrl, path)
+}
+
+// Returns the streaming url for path
+func (t *Twitter) streamUrl(path string) string {
+	return joinUrl(t.StreamUrl, DefaultStreamUrl, path)
+}
@@ -17,11 +17,16 @@
 package twitter
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/bsdf/twitter/twittertest"
 )
 
 type Config struct {
@@ -32,34 +37,58 @@ type Config struct {
 }
 
 var (
-	config Config
+	config = Config{
+		ConsumerKey:      "xvz1evFS4wEEPTGEFPHBog",
+		ConsumerSecret:   "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		OAuthToken:       "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		OAuthTokenSecret: "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+	}
 	tw     Twitter
+	server *twittertest.Server
 )
 
-func init() {
-	loadConfiguration()
+func TestMain(m *testing.M) {
+	server = newTestServer()
 
 	tw = Twitter{
 		ConsumerKey:      config.ConsumerKey,
 		ConsumerSecret:   config.ConsumerSecret,
 		OAuthToken:       config.OAuthToken,
 		OAuthTokenSecret: config.OAuthTokenSecret,
+		ApiUrl:           server.URL + "/1.1",
+		OAuthUrl:         server.URL,
 	}
+
+	code := m.Run()
+	server.Close()
+	os.Exit(code)
 }
 
-func loadConfiguration() {
-	file, err := os.Open(".config")
-	if err != nil {
-		fmt.Println("Error loading config:", err.Error())
-		os.Exit(1)
-	}
+// Starts a fake Twitter seeded with the users and tweets the tests expect.
+// Requests from tw act as @MEMEMEMEMES.
+func newTestServer() *twittertest.Server {
+	s := twittertest.NewServer(config.ConsumerKey, config.ConsumerSecret)
+	s.ClientId = "cHVibGljLWNsaWVudA"
 
-	body, err := ioutil.ReadAll(file)
-	err = json.Unmarshal(body, &config)
-	if err != nil {
-		fmt.Println("Error loading config:", err.Error())
-		os.Exit(1)
-	}
+	bsdf := s.AddUser(twittertest.User{Id: 14114455, ScreenName: "bsdf"})
+	me := s.AddUser(twittertest.User{Id: 76395009, ScreenName: "MEMEMEMEMES"})
+	s.AddToken(config.OAuthToken, config.OAuthTokenSecret, me.Id)
+
+	s.AddTweet(bsdf.Id, twittertest.Tweet{Text: "M83 Designs Children's Sneakers"})
+	s.AddTweet(me.Id, twittertest.Tweet{Id: 221281838440783875, Text: "listening to gucci mane"})
+	s.AddFriend(bsdf.Id, me.Id)
+
+	return s
+}
+
+// Returns a client acting as @MEMEMEMEMES on s
+func newClient(s *twittertest.Server) *Twitter {
+	tt := New(config.ConsumerKey, config.ConsumerSecret, config.OAuthToken, config.OAuthTokenSecret)
+	tt.ApiUrl = s.URL + "/1.1"
+	tt.OAuthUrl = s.URL
+	tt.StreamUrl = s.URL + "/1.1"
+	tt.UploadUrl = s.URL + "/1.1"
+	return tt
 }
 
 func debug(b bool) {
@@ -113,3 +142,104 @@ func TestTwitterType(t *testing.T) {
 		t.Error("Twitter object was not created correctly")
 	}
 }
+
+func TestWithToken(t *testing.T) {
+	s := newTestServer()
+	defer s.Close()
+	tt := newClient(s)
+	tt.WaitOnRateLimit = true
+
+	if _, err := tt.GetUser("bsdf"); err != nil {
+		t.Fatal("Error retrieving user:", err.Error())
+	}
+	if len(tt.RateLimits()) == 0 {
+		t.Fatal("Expected the request's rate limit to be tracked")
+	}
+
+	user := tt.WithToken("token", "secret")
+	if user.OAuthToken != "token" || user.OAuthTokenSecret != "secret" {
+		t.Error("WithToken did not set the access token")
+	}
+	if user.ConsumerKey != tt.ConsumerKey || user.ApiUrl != tt.ApiUrl || !user.WaitOnRateLimit {
+		t.Error("WithToken did not copy the client's configuration")
+	}
+	if len(user.RateLimits()) != 0 {
+		t.Errorf("Expected no rate limits on the new client, got %d", len(user.RateLimits()))
+	}
+}
+
+func TestBaseUrls(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path == "/oauth/request_token" {
+			fmt.Fprint(w, "oauth_token=abc&oauth_token_secret=def")
+			return
+		}
+		fmt.Fprint(w, `{"privacy":"Policy"}`)
+	}))
+	defer srv.Close()
+
+	tt := Twitter{
+		ConsumerKey:    "key",
+		ConsumerSecret: "secret",
+		ApiUrl:         srv.URL + "/1.1/",
+		OAuthUrl:       srv.URL,
+	}
+
+	policy, err := tt.GetPrivacyPolicy()
+	if err != nil {
+		t.Error("Error retrieving privacy policy from local server:", err.Error())
+		return
+	}
+	if policy != "Policy" {
+		t.Error("Privacy policy from local server was not returned")
+		return
+	}
+
+	if err = tt.requestToken(); err != nil {
+		t.Error("Error requesting token from local server:", err.Error())
+		return
+	}
+
+	expected := []string{"/1.1/help/privacy.json", "/oauth/request_token"}
+	if len(paths) != len(expected) {
+		t.Errorf("Expected %d requests, got %d", len(expected), len(paths))
+		return
+	}
+	for i, path := range expected {
+		if paths[i] != path {
+			t.Errorf("Expected request to %s, got %s", path, paths[i])
+		}
+	}
+
+	if tt.uploadUrl("media/upload.json") != DefaultUploadUrl+"/media/upload.json" {
+		t.Error("Empty UploadUrl did not fall back to the default")
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	tt := Twitter{ApiUrl: srv.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := tt.GetTOSContext(ctx)
+	if err == nil {
+		t.Error("No error returned after deadline passed")
+		return
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected deadline error, got:", err.Error())
+	}
+}
@@ -0,0 +1,251 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const accountActivityPrefix = "/1.1/account_activity/all/"
+
+type Webhook struct {
+	Id               string `json:"id"`
+	Url              string `json:"url"`
+	Valid            bool   `json:"valid"`
+	CreatedTimestamp string `json:"created_timestamp"`
+}
+
+// Returns the webhooks registered for env
+func (s *Server) Webhooks(env string) []Webhook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	webhooks := []Webhook{}
+	for _, w := range s.webhooks[env] {
+		webhooks = append(webhooks, *w)
+	}
+	return webhooks
+}
+
+// Routes the Account Activity endpoints, which have the environment
+// name and ids in the middle of their paths
+func (s *Server) accountActivityRoute(method, path string, r *request) (rt route, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, accountActivityPrefix), ".json"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "webhooks" && method == "GET":
+		return route{s.allWebhooks, bearerAuth}, true
+	case len(parts) < 2:
+		return
+	}
+
+	r.env = parts[0]
+	switch strings.Join(append([]string{method}, parts[1:]...), " ") {
+	case "POST webhooks":
+		return route{s.registerWebhook, userAuth}, true
+	case "GET webhooks":
+		return route{s.envWebhooks, bearerAuth}, true
+	case "POST subscriptions":
+		return route{s.addSubscription, userAuth}, true
+	case "GET subscriptions":
+		return route{s.checkSubscription, userAuth}, true
+	case "GET subscriptions list":
+		return route{s.listSubscriptions, bearerAuth}, true
+	}
+
+	if len(parts) == 3 {
+		r.webhookId = parts[2]
+		switch method + " " + parts[1] {
+		case "PUT webhooks":
+			return route{s.triggerCRC, userAuth}, true
+		case "DELETE webhooks":
+			return route{s.deleteWebhook, userAuth}, true
+		case "DELETE subscriptions":
+			return route{s.removeSubscription, bearerAuth}, true
+		}
+	}
+	return
+}
+
+func (s *Server) allWebhooks(w http.ResponseWriter, r *request) {
+	var envs []string
+	for env := range s.webhooks {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	environments := []map[string]interface{}{}
+	for _, env := range envs {
+		environments = append(environments, map[string]interface{}{
+			"environment_name": env,
+			"webhooks":         s.webhooks[env],
+		})
+	}
+	writeJSON(w, map[string]interface{}{"environments": environments})
+}
+
+func (s *Server) envWebhooks(w http.ResponseWriter, r *request) {
+	webhooks := s.webhooks[r.env]
+	if webhooks == nil {
+		webhooks = []*Webhook{}
+	}
+	writeJSON(w, webhooks)
+}
+
+func (s *Server) registerWebhook(w http.ResponseWriter, r *request) {
+	u := r.param("url")
+	if parsed, err := url.Parse(u); err != nil || parsed.Host == "" {
+		writeError(w, http.StatusBadRequest, 214, "Webhook URL must be https and should not include a port.")
+		return
+	}
+	for _, wh := range s.webhooks[r.env] {
+		if wh.Url == u {
+			writeError(w, http.StatusForbidden, 214, "Webhook URL already exists for this environment.")
+			return
+		}
+	}
+	if len(s.webhooks[r.env]) >= s.WebhookLimit {
+		writeError(w, http.StatusForbidden, 214, "Too many resources already created.")
+		return
+	}
+	if !s.checkCRC(u) {
+		writeError(w, http.StatusBadRequest, 214, "Webhook URL does not meet the requirements. Invalid CRC token or json response format.")
+		return
+	}
+
+	wh := &Webhook{
+		Id:               strconv.FormatInt(s.newId(), 10),
+		Url:              u,
+		Valid:            true,
+		CreatedTimestamp: time.Now().UTC().Format("2006-01-02 15:04:05 -0700"),
+	}
+	s.webhooks[r.env] = append(s.webhooks[r.env], wh)
+	writeJSON(w, wh)
+}
+
+func (s *Server) triggerCRC(w http.ResponseWriter, r *request) {
+	wh, _, ok := s.findWebhook(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+
+	if wh.Valid = s.checkCRC(wh.Url); !wh.Valid {
+		writeError(w, http.StatusBadRequest, 214, "Webhook URL does not meet the requirements. Invalid CRC token or json response format.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *request) {
+	_, i, ok := s.findWebhook(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+
+	webhooks := s.webhooks[r.env]
+	s.webhooks[r.env] = append(webhooks[:i:i], webhooks[i+1:]...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addSubscription(w http.ResponseWriter, r *request) {
+	if len(s.webhooks[r.env]) == 0 {
+		writeError(w, http.StatusBadRequest, 214, "Webhook does not exist for this environment.")
+		return
+	}
+
+	if s.subscribers[r.env] == nil {
+		s.subscribers[r.env] = make(map[int64]bool)
+	}
+	s.subscribers[r.env][r.userId] = true
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) checkSubscription(w http.ResponseWriter, r *request) {
+	if !s.subscribers[r.env][r.userId] {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSubscriptions(w http.ResponseWriter, r *request) {
+	var ids []int64
+	for id := range s.subscribers[r.env] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	subscriptions := []map[string]string{}
+	for _, id := range ids {
+		subscriptions = append(subscriptions, map[string]string{"user_id": strconv.FormatInt(id, 10)})
+	}
+	writeJSON(w, map[string]interface{}{
+		"environment":    r.env,
+		"application_id": "13090192",
+		"subscriptions":  subscriptions,
+	})
+}
+
+func (s *Server) removeSubscription(w http.ResponseWriter, r *request) {
+	id, _ := strconv.ParseInt(r.webhookId, 10, 64)
+	if !s.subscribers[r.env][id] {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+
+	delete(s.subscribers[r.env], id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the webhook named in r's path and its index
+func (s *Server) findWebhook(r *request) (wh *Webhook, i int, ok bool) {
+	for i, wh := range s.webhooks[r.env] {
+		if wh.Id == r.webhookId {
+			return wh, i, true
+		}
+	}
+	return
+}
+
+// Sends a challenge to the webhook at u, as Twitter does when it is
+// registered, and reports whether it answered correctly
+func (s *Server) checkCRC(u string) bool {
+	token := randomToken()
+
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(u + "?crc_token=" + url.QueryEscape(token))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var response = struct {
+		ResponseToken string `json:"response_token"`
+	}{}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&response) != nil {
+		return false
+	}
+	return response.ResponseToken == "sha256="+signSHA256(token, s.ConsumerSecret)
+}
@@ -0,0 +1,321 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest chunk accepted by APPEND, as on upload.twitter.com
+const maxMediaChunk = 5 << 20
+
+type media struct {
+	id         int64
+	mediaType  string
+	category   string
+	totalBytes int
+	segments   map[int][]byte
+	data       []byte
+	finalized  bool
+
+	// processing state once finalized, empty for synchronous media
+	state    string
+	progress int
+}
+
+type processingInfo struct {
+	State           string           `json:"state"`
+	CheckAfterSecs  int              `json:"check_after_secs,omitempty"`
+	ProgressPercent int              `json:"progress_percent,omitempty"`
+	Error           *processingError `json:"error,omitempty"`
+}
+
+type processingError struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// Returns the bytes of an uploaded, finalized media item
+func (s *Server) Media(id int64) (data []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.media[id]
+	if !ok || !m.finalized {
+		return nil, false
+	}
+	return m.data, true
+}
+
+// Handles POST media/upload, both the simple upload and the INIT,
+// APPEND and FINALIZE commands of a chunked one
+func (s *Server) uploadMedia(w http.ResponseWriter, r *request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxMediaChunk * 2); err != nil {
+			writeError(w, http.StatusBadRequest, 0, err.Error())
+			return
+		}
+	}
+
+	switch command := mediaParam(r, "command"); command {
+	case "":
+		s.simpleUpload(w, r)
+	case "INIT":
+		s.initUpload(w, r)
+	case "APPEND":
+		s.appendUpload(w, r)
+	case "FINALIZE":
+		s.finalizeUpload(w, r)
+	default:
+		writeError(w, http.StatusBadRequest, 38, "command parameter is invalid.")
+	}
+}
+
+func (s *Server) simpleUpload(w http.ResponseWriter, r *request) {
+	data, ok := mediaData(r)
+	if !ok || len(data) == 0 {
+		writeError(w, http.StatusBadRequest, 38, "media parameter is missing.")
+		return
+	}
+
+	m := &media{id: s.newId(), data: data, totalBytes: len(data), finalized: true}
+	s.media[m.id] = m
+	writeJSON(w, s.renderMedia(m))
+}
+
+func (s *Server) initUpload(w http.ResponseWriter, r *request) {
+	total, err := strconv.Atoi(mediaParam(r, "total_bytes"))
+	if err != nil || total < 1 {
+		writeError(w, http.StatusBadRequest, 38, "total_bytes parameter is missing.")
+		return
+	}
+	mediaType := mediaParam(r, "media_type")
+	if mediaType == "" {
+		writeError(w, http.StatusBadRequest, 38, "media_type parameter is missing.")
+		return
+	}
+
+	m := &media{
+		id:         s.newId(),
+		mediaType:  mediaType,
+		category:   mediaParam(r, "media_category"),
+		totalBytes: total,
+		segments:   make(map[int][]byte),
+	}
+	s.media[m.id] = m
+	writeJSON(w, s.renderMedia(m))
+}
+
+func (s *Server) appendUpload(w http.ResponseWriter, r *request) {
+	m, ok := s.lookupMedia(w, r)
+	if !ok {
+		return
+	}
+	if m.finalized {
+		writeError(w, http.StatusBadRequest, 324, "Media is already finalized.")
+		return
+	}
+
+	index, err := strconv.Atoi(mediaParam(r, "segment_index"))
+	if err != nil || index < 0 || index > 999 {
+		writeError(w, http.StatusBadRequest, 38, "segment_index parameter is invalid.")
+		return
+	}
+	data, ok := mediaData(r)
+	if !ok || len(data) == 0 {
+		writeError(w, http.StatusBadRequest, 38, "media parameter is missing.")
+		return
+	}
+	if len(data) > maxMediaChunk {
+		writeError(w, http.StatusBadRequest, 324, "Segment is larger than 5MB.")
+		return
+	}
+
+	m.segments[index] = data
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) finalizeUpload(w http.ResponseWriter, r *request) {
+	m, ok := s.lookupMedia(w, r)
+	if !ok {
+		return
+	}
+	if m.finalized {
+		writeError(w, http.StatusBadRequest, 324, "Media is already finalized.")
+		return
+	}
+
+	var indices []int
+	for i := range m.segments {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+
+	var data bytes.Buffer
+	for _, i := range indices {
+		data.Write(m.segments[i])
+	}
+	if data.Len() != m.totalBytes {
+		writeError(w, http.StatusBadRequest, 324, "File size does not match total_bytes.")
+		return
+	}
+
+	m.data = data.Bytes()
+	m.segments = nil
+	m.finalized = true
+	if m.category == "tweet_video" || m.category == "tweet_gif" {
+		m.state = "pending"
+	}
+	writeJSON(w, s.renderMedia(m))
+}
+
+// Handles GET media/upload?command=STATUS. Each check moves processing
+// along a step: pending, then in_progress, then succeeded, or failed if
+// the media type can't be transcoded.
+func (s *Server) mediaStatus(w http.ResponseWriter, r *request) {
+	if r.param("command") != "STATUS" {
+		writeError(w, http.StatusBadRequest, 38, "command parameter is invalid.")
+		return
+	}
+	m, ok := s.lookupMedia(w, r)
+	if !ok {
+		return
+	}
+	if m.state == "" {
+		writeError(w, http.StatusBadRequest, 324, "Media does not require processing.")
+		return
+	}
+
+	switch m.state {
+	case "pending":
+		m.state, m.progress = "in_progress", 50
+	case "in_progress":
+		if playable(m) {
+			m.state, m.progress = "succeeded", 100
+		} else {
+			m.state = "failed"
+		}
+	}
+	writeJSON(w, s.renderMedia(m))
+}
+
+func (s *Server) lookupMedia(w http.ResponseWriter, r *request) (m *media, ok bool) {
+	id, err := strconv.ParseInt(mediaParam(r, "media_id"), 10, 64)
+	if m, ok = s.media[id]; err != nil || !ok {
+		writeError(w, http.StatusBadRequest, 324, "Invalid media_id.")
+		return nil, false
+	}
+	return
+}
+
+// Checks media_ids from a status update. Media must be finalized and
+// done processing before it can be attached.
+func (s *Server) attachMedia(ids string) ([]MediaEntity, bool) {
+	var entities []MediaEntity
+	for _, str := range strings.Split(ids, ",") {
+		id, err := strconv.ParseInt(str, 10, 64)
+		m, ok := s.media[id]
+		if err != nil || !ok || !m.finalized || (m.state != "" && m.state != "succeeded") {
+			return nil, false
+		}
+
+		entities = append(entities, MediaEntity{
+			Id:       m.id,
+			IdStr:    strconv.FormatInt(m.id, 10),
+			Type:     mediaKind(m),
+			MediaUrl: "https://pbs.twimg.com/media/" + strconv.FormatInt(m.id, 10),
+		})
+	}
+	return entities, len(entities) > 0 && len(entities) <= 4
+}
+
+func (s *Server) renderMedia(m *media) map[string]interface{} {
+	out := map[string]interface{}{
+		"media_id":           m.id,
+		"media_id_string":    strconv.FormatInt(m.id, 10),
+		"expires_after_secs": 86400,
+	}
+	if m.finalized {
+		out["size"] = len(m.data)
+	}
+
+	if m.state != "" {
+		info := processingInfo{State: m.state, ProgressPercent: m.progress}
+		switch m.state {
+		case "pending", "in_progress":
+			info.CheckAfterSecs = s.MediaCheckAfter
+		case "failed":
+			info.Error = &processingError{Code: 1, Name: "InvalidMedia", Message: "Unsupported video format"}
+		}
+		out["processing_info"] = info
+	}
+	return out
+}
+
+// Returns a request parameter, including the fields of multipart bodies
+func mediaParam(r *request, name string) string {
+	if r.MultipartForm != nil {
+		if vs := r.MultipartForm.Value[name]; len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return r.param(name)
+}
+
+// Returns the uploaded bytes, sent either as a "media" file part or as
+// base64 in media_data
+func mediaData(r *request) ([]byte, bool) {
+	if r.MultipartForm != nil {
+		if files := r.MultipartForm.File["media"]; len(files) > 0 {
+			f, err := files[0].Open()
+			if err != nil {
+				return nil, false
+			}
+			defer f.Close()
+
+			data, err := ioutil.ReadAll(f)
+			return data, err == nil
+		}
+	}
+
+	if encoded := mediaParam(r, "media_data"); encoded != "" {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		return data, err == nil
+	}
+	return nil, false
+}
+
+func playable(m *media) bool {
+	return strings.HasPrefix(m.mediaType, "video/") || m.mediaType == "image/gif"
+}
+
+func mediaKind(m *media) string {
+	switch m.category {
+	case "tweet_video":
+		return "video"
+	case "tweet_gif":
+		return "animated_gif"
+	}
+	return "photo"
+}
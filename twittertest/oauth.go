@@ -0,0 +1,240 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Checks the credentials of a request to an endpoint authorized by auth.
+// Returns the error to respond with if they are not valid.
+func (s *Server) authenticate(r *request, auth authType) *Error {
+	header := r.Header.Get("Authorization")
+
+	switch {
+	case auth == consumerAuth:
+		key, secret, ok := r.BasicAuth()
+		if !ok || key != encode(s.ConsumerKey) || secret != encode(s.ConsumerSecret) {
+			return &Error{Status: http.StatusForbidden, Code: 99, Message: "Unable to verify your credentials"}
+		}
+		return nil
+
+	case auth == clientAuth:
+		key, secret, ok := r.BasicAuth()
+		if s.ClientSecret == "" {
+			key, secret, ok = r.param("client_id"), "", true
+		}
+		if !ok || key != encode(s.ClientId) || secret != encode(s.ClientSecret) {
+			return &Error{Status: http.StatusUnauthorized, Body: `{"error":"unauthorized_client","error_description":"Missing valid authorization header"}`}
+		}
+		return nil
+
+	case strings.HasPrefix(header, "Bearer "):
+		bearer := strings.TrimPrefix(header, "Bearer ")
+
+		// OAuth 2.0 user tokens act as their user
+		if tok, ok := s.oauth2Tokens[bearer]; ok && time.Now().Before(tok.expiry) {
+			r.userId = tok.userId
+			return nil
+		}
+
+		if !s.bearerTokens[bearer] {
+			return &Error{Status: http.StatusUnauthorized, Code: 89, Message: "Invalid or expired token."}
+		}
+		if auth != appAuth && auth != bearerAuth {
+			return &Error{Status: http.StatusForbidden, Code: 220, Message: "Your credentials do not allow access to this resource."}
+		}
+		r.app = true
+		return nil
+
+	case auth == bearerAuth:
+		return &Error{Status: http.StatusForbidden, Code: 220, Message: "Your credentials do not allow access to this resource."}
+	}
+
+	if !s.verifySignature(r) {
+		return &Error{Status: http.StatusUnauthorized, Code: 32, Message: "Could not authenticate you."}
+	}
+	return nil
+}
+
+// Checks the OAuth 1.0a signature of r, recording the credentials it
+// was signed with. Requests for a request token are signed without a
+// token; every other request needs a registered access token.
+func (s *Server) verifySignature(r *request) bool {
+	params, ok := parseAuthorization(r.Header.Get("Authorization"))
+	if !ok {
+		return false
+	}
+	r.oauth = params
+
+	if params["oauth_consumer_key"] != s.ConsumerKey ||
+		params["oauth_signature_method"] != "HMAC-SHA1" ||
+		params["oauth_nonce"] == "" || params["oauth_timestamp"] == "" {
+		return false
+	}
+
+	var tokenSecret string
+	switch r.URL.Path {
+	case "/oauth/request_token":
+		// signed with the consumer credentials alone
+	case "/oauth/access_token":
+		tok, ok := s.requestTokens[params["oauth_token"]]
+		if !ok {
+			return false
+		}
+		tokenSecret = tok.secret
+	default:
+		tok, ok := s.accessTokens[params["oauth_token"]]
+		if !ok {
+			return false
+		}
+		tokenSecret = tok.secret
+		r.userId = tok.userId
+	}
+
+	// a nonce may only be used once
+	nonce := params["oauth_timestamp"] + params["oauth_nonce"]
+	if s.nonces[nonce] {
+		return false
+	}
+
+	expected := sign(signatureBase(r, params), s.ConsumerSecret, tokenSecret)
+	if !hmac.Equal([]byte(expected), []byte(params["oauth_signature"])) {
+		return false
+	}
+
+	s.nonces[nonce] = true
+	return true
+}
+
+// Parses the parameters of an `OAuth k="v", ...` header
+func parseAuthorization(header string) (params map[string]string, ok bool) {
+	if !strings.HasPrefix(header, "OAuth ") {
+		return
+	}
+
+	params = make(map[string]string)
+	for _, pair := range strings.Split(header[len("OAuth "):], ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, false
+		}
+
+		v, err := url.QueryUnescape(strings.Trim(kv[1], `"`))
+		if err != nil {
+			return nil, false
+		}
+		params[kv[0]] = v
+	}
+	return params, true
+}
+
+// Builds the signature base string as described in RFC 5849 3.4.1
+func signatureBase(r *request, oauth map[string]string) string {
+	var pairs [][2]string
+	add := func(k, v string) {
+		pairs = append(pairs, [2]string{encode(k), encode(v)})
+	}
+
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			add(k, v)
+		}
+	}
+	for k, vs := range r.form {
+		for _, v := range vs {
+			add(k, v)
+		}
+	}
+	for k, v := range oauth {
+		if k != "oauth_signature" {
+			add(k, v)
+		}
+	}
+
+	// sort by key, then by value
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	joined := make([]string, len(pairs))
+	for i, p := range pairs {
+		joined[i] = p[0] + "=" + p[1]
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	baseUrl := scheme + "://" + strings.ToLower(r.Host) + r.URL.Path
+
+	return r.Method + "&" + encode(baseUrl) + "&" + encode(strings.Join(joined, "&"))
+}
+
+// Checks a PKCE code verifier against the challenge sent when the
+// code was authorized
+func verifyChallenge(code *authCode, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+
+	challenge := verifier
+	if code.challengeType == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return challenge == code.challenge
+}
+
+func sign(base, consumerSecret, tokenSecret string) string {
+	mac := hmac.New(sha1.New, []byte(encode(consumerSecret)+"&"+encode(tokenSecret)))
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signs message with HMAC-SHA256, as for webhook CRC checks
+func signSHA256(message, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Percent encodes str as required by RFC 5849 3.6
+func encode(str string) string {
+	esc := url.QueryEscape(str)
+	esc = strings.Replace(esc, "*", "%2A", -1)
+	return strings.Replace(esc, "+", "%20", -1)
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
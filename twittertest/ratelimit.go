@@ -0,0 +1,155 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Requests allowed per window to a GET endpoint without its own limit
+const DefaultRateLimit = 180
+
+// A rate limit window for one caller and resource
+type window struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// Sets the number of requests allowed per window to resource, eg.
+// "/search/tweets". Windows already open keep their old limit.
+func (s *Server) SetRateLimit(resource string, limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits[resource] = limit
+}
+
+// Counts a request against its rate limit window and reports the
+// window in x-rate-limit-* headers. Returns the error to respond with
+// once the window is exhausted.
+func (s *Server) limitRate(w http.ResponseWriter, r *request) *Error {
+	win := s.window(s.caller(r), r.resource)
+	if win.remaining == 0 {
+		return &Error{
+			Status:  http.StatusTooManyRequests,
+			Code:    88,
+			Message: "Rate limit exceeded",
+			Header:  rateLimitHeader(win),
+		}
+	}
+
+	win.remaining--
+	for k, v := range rateLimitHeader(win) {
+		w.Header()[k] = v
+	}
+	return nil
+}
+
+// Returns the open window for caller and resource, starting a new one
+// if the last has reset
+func (s *Server) window(caller, resource string) *window {
+	key := caller + " " + resource
+
+	win, ok := s.windows[key]
+	if !ok || !time.Now().Before(win.reset) {
+		limit, ok := s.limits[resource]
+		if !ok {
+			limit = DefaultRateLimit
+		}
+
+		win = &window{limit: limit, remaining: limit, reset: time.Now().Add(s.RateLimitWindow)}
+		s.windows[key] = win
+	}
+	return win
+}
+
+// Identifies who a request is rate limited as. Application-only
+// requests share a limit separate from every user.
+func (s *Server) caller(r *request) string {
+	if r.app {
+		return "app"
+	}
+	return fmt.Sprint("user:", r.userId)
+}
+
+func rateLimitHeader(win *window) http.Header {
+	return http.Header{
+		"X-Rate-Limit-Limit":     {strconv.Itoa(win.limit)},
+		"X-Rate-Limit-Remaining": {strconv.Itoa(win.remaining)},
+		"X-Rate-Limit-Reset":     {strconv.FormatInt(resetUnix(win.reset), 10)},
+	}
+}
+
+// Reset times are reported to the second, rounded up so a client
+// waiting for one never retries before the window has reset
+func resetUnix(reset time.Time) int64 {
+	return reset.Add(time.Second - 1).Unix()
+}
+
+func (s *Server) rateLimitStatus(w http.ResponseWriter, r *request) {
+	var families []string
+	if str := r.param("resources"); str != "" {
+		families = strings.Split(str, ",")
+	}
+
+	resources := make(map[string]map[string]interface{})
+	for key := range s.routes() {
+		if !strings.HasPrefix(key, "GET /1.1/") {
+			continue
+		}
+
+		resource := resourceName(strings.TrimPrefix(key, "GET "))
+		family := strings.SplitN(resource, "/", 3)[1]
+		if families != nil && !contains(families, family) {
+			continue
+		}
+
+		win := s.window(s.caller(r), resource)
+		if resources[family] == nil {
+			resources[family] = make(map[string]interface{})
+		}
+		resources[family][resource] = map[string]interface{}{
+			"limit":     win.limit,
+			"remaining": win.remaining,
+			"reset":     resetUnix(win.reset),
+		}
+	}
+
+	context := map[string]string{"access_token": r.oauth["oauth_token"]}
+	if r.app {
+		context = map[string]string{"application": s.ConsumerKey}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"rate_limit_context": context,
+		"resources":          resources,
+	})
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1187 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package twittertest provides an in-process fake of the Twitter API
+// for hermetic tests.
+//
+// The fake keeps users, tweets, friendships and direct messages in
+// memory, verifies the OAuth 1.0a signature or application-only bearer
+// token of every request, and can be told to fail requests to a path
+// with InjectError. Point a client at it
+// by setting its ApiUrl to server.URL + "/1.1" and its OAuthUrl to
+// server.URL.
+package twittertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type User struct {
+	Id             int64  `json:"id"`
+	IdStr          string `json:"id_str"`
+	Name           string `json:"name"`
+	ScreenName     string `json:"screen_name"`
+	FollowersCount int    `json:"followers_count"`
+	FriendsCount   int    `json:"friends_count"`
+	Lang           string `json:"lang"`
+	Location       string `json:"location"`
+}
+
+type Tweet struct {
+	Id           int64  `json:"id"`
+	IdStr        string `json:"id_str"`
+	CreatedAt    string `json:"created_at"`
+	Text         string `json:"text"`
+	Retweeted    bool   `json:"retweeted"`
+	RetweetCount int    `json:"retweet_count"`
+	User         User   `json:"user"`
+
+	InReplyToStatusId int64     `json:"in_reply_to_status_id,omitempty"`
+	RetweetedStatus   *Tweet    `json:"retweeted_status,omitempty"`
+	Entities          *Entities `json:"entities,omitempty"`
+}
+
+type Entities struct {
+	Media []MediaEntity `json:"media,omitempty"`
+}
+
+type MediaEntity struct {
+	Id       int64  `json:"id"`
+	IdStr    string `json:"id_str"`
+	Type     string `json:"type"`
+	MediaUrl string `json:"media_url_https"`
+}
+
+type DirectMessage struct {
+	Id                  int64  `json:"id"`
+	IdStr               string `json:"id_str"`
+	CreatedAt           string `json:"created_at"`
+	Text                string `json:"text"`
+	Sender              User   `json:"sender"`
+	SenderId            int64  `json:"sender_id"`
+	SenderScreenName    string `json:"sender_screen_name"`
+	Recipient           User   `json:"recipient"`
+	RecipientId         int64  `json:"recipient_id"`
+	RecipientScreenName string `json:"recipient_screen_name"`
+}
+
+// A failure the server returns in place of handling a request
+type Error struct {
+	Status  int
+	Code    int
+	Message string
+
+	// Raw response body sent instead of the JSON errors array,
+	// eg. an HTML "over capacity" page. An Error without a Code,
+	// Message or Body is sent with an empty body.
+	Body string
+
+	// Extra response headers
+	Header http.Header
+}
+
+// A request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Query  url.Values
+	Form   url.Values
+
+	// When the request arrived
+	Time time.Time
+}
+
+// An OAuth 2.0 authorization code awaiting exchange
+type authCode struct {
+	userId        int64
+	clientId      string
+	redirectUri   string
+	challenge     string
+	challengeType string
+}
+
+// An OAuth 2.0 user access token
+type oauth2Token struct {
+	userId int64
+	expiry time.Time
+}
+
+type token struct {
+	secret   string
+	userId   int64
+	callback string
+	verifier string
+}
+
+type Server struct {
+	*httptest.Server
+
+	ConsumerKey    string
+	ConsumerSecret string
+
+	// OAuth 2.0 client credentials. Leave ClientSecret empty to act as
+	// a public client.
+	ClientId     string
+	ClientSecret string
+
+	// How long OAuth 2.0 user tokens are valid for
+	OAuth2TokenLifetime time.Duration
+
+	// Length of a rate limit window. GET endpoints under /1.1 allow
+	// DefaultRateLimit requests per window unless set with SetRateLimit.
+	RateLimitWindow time.Duration
+
+	// How often connected streams are sent a blank keep-alive line.
+	// Streams stall if zero.
+	StreamKeepAlive time.Duration
+
+	// Seconds media STATUS responses tell clients to wait before
+	// checking again
+	MediaCheckAfter int
+
+	// Webhooks allowed per Account Activity environment. Defaults to
+	// one, as on the free tier.
+	WebhookLimit int
+
+	// Terms of service and privacy policy returned by help/*
+	TOS     string
+	Privacy string
+
+	mu            sync.Mutex
+	nextId        int64
+	users         map[int64]*User
+	screenNames   map[string]int64
+	tweets        map[int64]*Tweet
+	friends       map[int64][]int64
+	dms           map[int64]*DirectMessage
+	accessTokens  map[string]*token
+	requestTokens map[string]*token
+	bearerTokens  map[string]bool
+	authCodes     map[string]*authCode
+	oauth2Tokens  map[string]*oauth2Token
+	refreshTokens map[string]int64
+	nonces        map[string]bool
+	errors        map[string][]Error
+	requests      []Request
+	limits        map[string]int
+	windows       map[string]*window
+	streams       map[*subscription]bool
+	webhooks      map[string][]*Webhook
+	subscribers   map[string]map[int64]bool
+	media         map[int64]*media
+}
+
+// Starts a fake Twitter server accepting requests signed with the
+// given consumer credentials. Close it when done.
+func NewServer(consumerKey, consumerSecret string) *Server {
+	s := &Server{
+		ConsumerKey:         consumerKey,
+		ConsumerSecret:      consumerSecret,
+		TOS:                 "Terms of Service",
+		Privacy:             "Privacy Policy",
+		OAuth2TokenLifetime: 2 * time.Hour,
+		RateLimitWindow:     15 * time.Minute,
+		nextId:              1000,
+		users:               make(map[int64]*User),
+		screenNames:         make(map[string]int64),
+		tweets:              make(map[int64]*Tweet),
+		friends:             make(map[int64][]int64),
+		dms:                 make(map[int64]*DirectMessage),
+		accessTokens:        make(map[string]*token),
+		requestTokens:       make(map[string]*token),
+		bearerTokens:        make(map[string]bool),
+		authCodes:           make(map[string]*authCode),
+		oauth2Tokens:        make(map[string]*oauth2Token),
+		refreshTokens:       make(map[string]int64),
+		nonces:              make(map[string]bool),
+		errors:              make(map[string][]Error),
+		limits:              make(map[string]int),
+		windows:             make(map[string]*window),
+		streams:             make(map[*subscription]bool),
+		webhooks:            make(map[string][]*Webhook),
+		subscribers:         make(map[string]map[int64]bool),
+		media:               make(map[int64]*media),
+		MediaCheckAfter:     1,
+		WebhookLimit:        1,
+		StreamKeepAlive:     30 * time.Second,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Adds a user, assigning an id if u.Id is zero
+func (s *Server) AddUser(u User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u.Id == 0 {
+		u.Id = s.newId()
+	}
+	u.IdStr = strconv.FormatInt(u.Id, 10)
+	if u.Name == "" {
+		u.Name = u.ScreenName
+	}
+
+	s.users[u.Id] = &u
+	s.screenNames[strings.ToLower(u.ScreenName)] = u.Id
+	return u
+}
+
+// Registers an access token acting on behalf of userId
+func (s *Server) AddToken(oauthToken, oauthTokenSecret string, userId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessTokens[oauthToken] = &token{secret: oauthTokenSecret, userId: userId}
+}
+
+// Adds a tweet by userId, assigning an id if tw.Id is zero
+func (s *Server) AddTweet(userId int64, tw Tweet) Tweet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tw.Id == 0 {
+		tw.Id = s.newId()
+	}
+	tw.IdStr = strconv.FormatInt(tw.Id, 10)
+	if tw.CreatedAt == "" {
+		tw.CreatedAt = createdAt()
+	}
+	tw.User = User{Id: userId}
+
+	s.tweets[tw.Id] = &tw
+	s.publish(&tw)
+	return s.render(&tw)
+}
+
+// Simulates userId approving the request token on the authorize page.
+// Returns the oauth_verifier that would be sent to the callback, or
+// shown as a PIN for out-of-band tokens.
+func (s *Server) Authorize(requestToken string, userId int64) (verifier string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tok, ok := s.requestTokens[requestToken]
+	if !ok {
+		return "", fmt.Errorf("unknown request token %q", requestToken)
+	}
+	if _, ok := s.users[userId]; !ok {
+		return "", fmt.Errorf("unknown user %d", userId)
+	}
+
+	tok.userId = userId
+	tok.verifier = fmt.Sprintf("%07d", s.newId())
+	return tok.verifier, nil
+}
+
+// Simulates userId approving the OAuth 2.0 authorization request at
+// authorizeUrl. Returns the code that would be sent to its redirect_uri.
+func (s *Server) AuthorizeCode(authorizeUrl string, userId int64) (code string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := url.Parse(authorizeUrl)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+
+	switch {
+	case q.Get("response_type") != "code":
+		return "", fmt.Errorf("unsupported response_type %q", q.Get("response_type"))
+	case q.Get("client_id") != s.ClientId:
+		return "", fmt.Errorf("unknown client_id %q", q.Get("client_id"))
+	case q.Get("code_challenge") == "":
+		return "", fmt.Errorf("missing code_challenge")
+	}
+	if _, ok := s.users[userId]; !ok {
+		return "", fmt.Errorf("unknown user %d", userId)
+	}
+
+	code = randomToken()
+	s.authCodes[code] = &authCode{
+		userId:        userId,
+		clientId:      q.Get("client_id"),
+		redirectUri:   q.Get("redirect_uri"),
+		challenge:     q.Get("code_challenge"),
+		challengeType: q.Get("code_challenge_method"),
+	}
+	return
+}
+
+// Makes userId follow friendId
+func (s *Server) AddFriend(userId, friendId int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.follow(userId, friendId)
+}
+
+// Returns the tweets by userId, newest first
+func (s *Server) Tweets(userId int64) []Tweet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.timeline(userId)
+}
+
+// Makes the next request to path fail with e. Paths are matched
+// exactly, eg. "/1.1/statuses/update.json". Errors queued for the same
+// path are returned in order.
+func (s *Server) InjectError(path string, e Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[path] = append(s.errors[path], e)
+}
+
+// Returns every request received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 0, err.Error())
+		return
+	}
+
+	// handlers that take multipart bodies parse them again
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	form := url.Values{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, _ = url.ParseQuery(string(body))
+	}
+
+	s.mu.Lock()
+	sub := s.handle(w, r, form)
+	s.mu.Unlock()
+
+	// streams are served without holding the lock, so that tweets
+	// can be added while they are connected
+	if sub != nil {
+		s.serveStream(w, r, sub)
+	}
+}
+
+// Records and handles r. s.mu must be held. Returns the subscription
+// to serve if r opened a stream.
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, form url.Values) *subscription {
+	s.requests = append(s.requests, Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header,
+		Query:  r.URL.Query(),
+		Form:   form,
+		Time:   time.Now(),
+	})
+
+	if queued := s.errors[r.URL.Path]; len(queued) > 0 {
+		s.errors[r.URL.Path] = queued[1:]
+		writeInjected(w, queued[0])
+		return nil
+	}
+
+	req := &request{Request: r, form: form}
+	rt, ok := s.route(r.Method, r.URL.Path, req)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return nil
+	}
+
+	if e := s.authenticate(req, rt.auth); e != nil {
+		writeInjected(w, *e)
+		return nil
+	}
+
+	if req.resource != "" {
+		if e := s.limitRate(w, req); e != nil {
+			writeInjected(w, *e)
+			return nil
+		}
+	}
+	rt.handler(w, req)
+	return req.stream
+}
+
+// An incoming request along with its parsed form body and the
+// credentials it was signed with
+type request struct {
+	*http.Request
+	form   url.Values
+	oauth  map[string]string
+	userId int64
+	id     int64
+
+	// authorized with an application-only bearer token
+	app bool
+
+	// rate limited resource the request counts against, if any
+	resource string
+
+	// set by stream handlers to keep the connection open
+	stream *subscription
+
+	// Account Activity environment and webhook in the path
+	env       string
+	webhookId string
+}
+
+// Returns the named parameter from the query string or form body
+func (r *request) param(name string) string {
+	if v := r.form.Get(name); v != "" {
+		return v
+	}
+	return r.URL.Query().Get(name)
+}
+
+type handler func(http.ResponseWriter, *request)
+
+// How an endpoint may be authorized
+type authType int
+
+const (
+	// OAuth 1.0a signed with an access token
+	userAuth authType = iota
+	// OAuth 1.0a or an application-only bearer token
+	appAuth
+	// HTTP basic auth with the consumer credentials
+	consumerAuth
+	// HTTP basic auth with the OAuth 2.0 client credentials, or the
+	// client_id parameter for public clients
+	clientAuth
+	// an application-only bearer token
+	bearerAuth
+)
+
+type route struct {
+	handler handler
+	auth    authType
+}
+
+// Finds the route for a request. Path segments of the form :id.json
+// are parsed into r.id, and GET requests to /1.1 are assigned the
+// rate limited resource they count against, eg. "/statuses/show/:id".
+func (s *Server) route(method, path string, r *request) (rt route, ok bool) {
+	routes := s.routes()
+
+	defer func() {
+		if ok && method == "GET" && strings.HasPrefix(path, "/1.1/") {
+			r.resource = resourceName(path)
+		}
+	}()
+
+	if rt, ok = routes[method+" "+path]; ok {
+		return
+	}
+	if strings.HasPrefix(path, accountActivityPrefix) {
+		return s.accountActivityRoute(method, path, r)
+	}
+
+	// retry with the trailing id replaced by :id
+	i := strings.LastIndex(path, "/")
+	if i < 0 || !strings.HasSuffix(path, ".json") {
+		return
+	}
+	id, err := strconv.ParseInt(strings.TrimSuffix(path[i+1:], ".json"), 10, 64)
+	if err != nil {
+		return
+	}
+	r.id = id
+	path = path[:i+1] + ":id.json"
+	rt, ok = routes[method+" "+path]
+	return
+}
+
+// Returns the server's endpoints, keyed by method and path
+func (s *Server) routes() map[string]route {
+	return map[string]route{
+		"GET /1.1/statuses/user_timeline.json":        {s.userTimeline, appAuth},
+		"POST /1.1/statuses/update.json":              {s.update, userAuth},
+		"POST /1.1/media/upload.json":                 {s.uploadMedia, userAuth},
+		"GET /1.1/media/upload.json":                  {s.mediaStatus, userAuth},
+		"POST /1.1/statuses/retweet/:id.json":         {s.retweet, userAuth},
+		"POST /1.1/statuses/destroy/:id.json":         {s.destroy, userAuth},
+		"POST /1.1/friendships/create.json":           {s.createFriendship, userAuth},
+		"POST /1.1/friendships/destroy.json":          {s.destroyFriendship, userAuth},
+		"POST /1.1/statuses/filter.json":              {s.filterStream, userAuth},
+		"GET /1.1/statuses/sample.json":               {s.sampleStream, userAuth},
+		"GET /1.1/friends/ids.json":                   {s.friendIds, appAuth},
+		"GET /1.1/followers/ids.json":                 {s.followerIds, appAuth},
+		"GET /1.1/search/tweets.json":                 {s.search, appAuth},
+		"GET /1.1/users/show.json":                    {s.showUser, appAuth},
+		"GET /1.1/users/lookup.json":                  {s.lookupUsers, appAuth},
+		"GET /1.1/direct_messages.json":               {s.directMessages, userAuth},
+		"POST /1.1/direct_messages/new.json":          {s.newDirectMessage, userAuth},
+		"POST /1.1/direct_messages/destroy/:id.json":  {s.destroyDirectMessage, userAuth},
+		"GET /1.1/help/tos.json":                      {s.tos, appAuth},
+		"GET /1.1/help/privacy.json":                  {s.privacy, appAuth},
+		"GET /1.1/application/rate_limit_status.json": {s.rateLimitStatus, appAuth},
+		"POST /oauth/request_token":                   {s.requestToken, userAuth},
+		"POST /oauth/access_token":                    {s.accessToken, userAuth},
+		"POST /oauth2/token":                          {s.bearerToken, consumerAuth},
+		"POST /oauth2/invalidate_token":               {s.invalidateBearerToken, consumerAuth},
+		"POST /2/oauth2/token":                        {s.oauth2Token, clientAuth},
+	}
+}
+
+// Returns the rate limit resource for a /1.1 path, eg.
+// "/statuses/show/:id" for "/1.1/statuses/show/:id.json"
+func resourceName(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(path, "/1.1"), ".json")
+}
+
+func (s *Server) userTimeline(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+
+	count := 20
+	if str := r.param("count"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, 44, "count parameter is invalid.")
+			return
+		}
+		if count = n; count > 200 {
+			count = 200
+		}
+	}
+	sinceId, _ := strconv.ParseInt(r.param("since_id"), 10, 64)
+	maxId, _ := strconv.ParseInt(r.param("max_id"), 10, 64)
+
+	// like Twitter, count is applied before replies and retweets are
+	// filtered out, so filtered pages may be short or even empty
+	tweets := []Tweet{}
+	served := 0
+	for _, tw := range s.timeline(u.Id) {
+		if tw.Id <= sinceId || maxId != 0 && tw.Id > maxId {
+			continue
+		}
+		if served == count {
+			break
+		}
+		served++
+
+		switch {
+		case r.param("exclude_replies") == "true" && tw.InReplyToStatusId != 0:
+		case r.param("include_rts") == "false" && tw.RetweetedStatus != nil:
+		default:
+			if r.param("trim_user") == "true" {
+				tw.User = User{Id: tw.User.Id, IdStr: tw.User.IdStr}
+			}
+			tweets = append(tweets, tw)
+		}
+	}
+	writeJSON(w, tweets)
+}
+
+func (s *Server) update(w http.ResponseWriter, r *request) {
+	status := r.param("status")
+	if status == "" {
+		writeError(w, http.StatusForbidden, 170, "Missing required parameter: status.")
+		return
+	}
+	for _, tw := range s.tweets {
+		if tw.User.Id == r.userId && tw.Text == status {
+			writeError(w, http.StatusForbidden, 187, "Status is a duplicate.")
+			return
+		}
+	}
+
+	tw := &Tweet{Id: s.newId(), CreatedAt: createdAt(), Text: status, User: User{Id: r.userId}}
+	if ids := r.param("media_ids"); ids != "" {
+		media, ok := s.attachMedia(ids)
+		if !ok {
+			writeError(w, http.StatusBadRequest, 324, "Some of the provided media ids are invalid.")
+			return
+		}
+		tw.Entities = &Entities{Media: media}
+	}
+	tw.IdStr = strconv.FormatInt(tw.Id, 10)
+	s.tweets[tw.Id] = tw
+	s.publish(tw)
+	writeJSON(w, s.render(tw))
+}
+
+func (s *Server) retweet(w http.ResponseWriter, r *request) {
+	original, ok := s.tweets[r.id]
+	if !ok {
+		writeError(w, http.StatusNotFound, 144, "No status found with that ID.")
+		return
+	}
+
+	original.RetweetCount++
+	tw := &Tweet{
+		Id:              s.newId(),
+		CreatedAt:       createdAt(),
+		Text:            "RT @" + s.users[original.User.Id].ScreenName + ": " + original.Text,
+		User:            User{Id: r.userId},
+		RetweetedStatus: original,
+	}
+	tw.IdStr = strconv.FormatInt(tw.Id, 10)
+	s.tweets[tw.Id] = tw
+	s.publish(tw)
+	writeJSON(w, s.render(tw))
+}
+
+func (s *Server) destroy(w http.ResponseWriter, r *request) {
+	tw, ok := s.tweets[r.id]
+	if !ok {
+		writeError(w, http.StatusNotFound, 144, "No status found with that ID.")
+		return
+	}
+	if tw.User.Id != r.userId {
+		writeError(w, http.StatusForbidden, 183, "You may not delete another user's status.")
+		return
+	}
+
+	delete(s.tweets, r.id)
+	writeJSON(w, s.render(tw))
+}
+
+func (s *Server) createFriendship(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 108, "Cannot find specified user.")
+		return
+	}
+	s.follow(r.userId, u.Id)
+	writeJSON(w, s.renderUser(u.Id))
+}
+
+func (s *Server) destroyFriendship(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 108, "Cannot find specified user.")
+		return
+	}
+
+	friends := s.friends[r.userId]
+	for i, id := range friends {
+		if id == u.Id {
+			s.friends[r.userId] = append(friends[:i:i], friends[i+1:]...)
+			break
+		}
+	}
+	writeJSON(w, s.renderUser(u.Id))
+}
+
+func (s *Server) friendIds(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+	s.writeIds(w, r, s.friends[u.Id])
+}
+
+func (s *Server) followerIds(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+	s.writeIds(w, r, s.followers(u.Id))
+}
+
+// Writes the page of ids selected by the cursor and count parameters.
+// Cursors are offsets into ids, with -1 for the first page.
+func (s *Server) writeIds(w http.ResponseWriter, r *request, ids []int64) {
+	count := 5000
+	if str := r.param("count"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, 44, "count parameter is invalid.")
+			return
+		}
+		if n < count {
+			count = n
+		}
+	}
+
+	offset := int64(0)
+	if str := r.param("cursor"); str != "" && str != "-1" {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil || n < 0 || n > int64(len(ids)) {
+			writeError(w, http.StatusBadRequest, 44, "cursor parameter is invalid.")
+			return
+		}
+		offset = n
+	}
+
+	end := offset + int64(count)
+	next := end
+	if end >= int64(len(ids)) {
+		end, next = int64(len(ids)), 0
+	}
+	previous := offset - int64(count)
+	if offset == 0 {
+		previous = 0
+	} else if previous <= 0 {
+		previous = -1
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"ids":                 append([]int64{}, ids[offset:end]...),
+		"next_cursor":         next,
+		"next_cursor_str":     strconv.FormatInt(next, 10),
+		"previous_cursor":     previous,
+		"previous_cursor_str": strconv.FormatInt(previous, 10),
+	})
+}
+
+func (s *Server) search(w http.ResponseWriter, r *request) {
+	q := strings.ToLower(r.param("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, 25, "Query parameters are missing.")
+		return
+	}
+
+	count := 15
+	if str := r.param("count"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, 44, "count parameter is invalid.")
+			return
+		}
+		if count = n; count > 100 {
+			count = 100
+		}
+	}
+	sinceId, _ := strconv.ParseInt(r.param("since_id"), 10, 64)
+	maxId, _ := strconv.ParseInt(r.param("max_id"), 10, 64)
+
+	statuses := []Tweet{}
+	more := false
+	for _, tw := range s.allTweets() {
+		if tw.Id <= sinceId || maxId != 0 && tw.Id > maxId {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(tw.Text), q) {
+			continue
+		}
+		if len(statuses) == count {
+			more = true
+			break
+		}
+		statuses = append(statuses, tw)
+	}
+
+	metadata := map[string]interface{}{
+		"query":    url.QueryEscape(r.param("q")),
+		"count":    count,
+		"since_id": sinceId,
+		"max_id":   maxId,
+	}
+	if len(statuses) > 0 {
+		metadata["max_id"] = statuses[0].Id
+	}
+	if more {
+		next := url.Values{}
+		next.Set("max_id", strconv.FormatInt(statuses[len(statuses)-1].Id-1, 10))
+		next.Set("q", r.param("q"))
+		next.Set("count", strconv.Itoa(count))
+		next.Set("include_entities", "1")
+		metadata["next_results"] = "?" + next.Encode()
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"statuses":        statuses,
+		"search_metadata": metadata,
+	})
+}
+
+func (s *Server) showUser(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 50, "User not found.")
+		return
+	}
+	writeJSON(w, s.renderUser(u.Id))
+}
+
+func (s *Server) lookupUsers(w http.ResponseWriter, r *request) {
+	users := []User{}
+	for _, str := range strings.Split(r.param("user_id"), ",") {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := s.users[id]; ok {
+			users = append(users, s.renderUser(id))
+		}
+	}
+	for _, name := range strings.Split(r.param("screen_name"), ",") {
+		if id, ok := s.screenNames[strings.ToLower(name)]; ok {
+			users = append(users, s.renderUser(id))
+		}
+	}
+
+	if len(users) == 0 {
+		writeError(w, http.StatusNotFound, 17, "No user matches for specified terms.")
+		return
+	}
+	writeJSON(w, users)
+}
+
+func (s *Server) directMessages(w http.ResponseWriter, r *request) {
+	dms := []DirectMessage{}
+	for _, dm := range s.dms {
+		if dm.RecipientId == r.userId {
+			dms = append(dms, s.renderDM(dm))
+		}
+	}
+	sort.Sort(byDMId(dms))
+	writeJSON(w, dms)
+}
+
+func (s *Server) newDirectMessage(w http.ResponseWriter, r *request) {
+	u, ok := s.lookupUser(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, 108, "Cannot find specified user.")
+		return
+	}
+
+	dm := &DirectMessage{
+		Id:          s.newId(),
+		CreatedAt:   createdAt(),
+		Text:        r.param("text"),
+		SenderId:    r.userId,
+		RecipientId: u.Id,
+	}
+	s.dms[dm.Id] = dm
+	writeJSON(w, s.renderDM(dm))
+}
+
+func (s *Server) destroyDirectMessage(w http.ResponseWriter, r *request) {
+	dm, ok := s.dms[r.id]
+	if !ok || (dm.SenderId != r.userId && dm.RecipientId != r.userId) {
+		writeError(w, http.StatusNotFound, 34, "Sorry, that page does not exist.")
+		return
+	}
+
+	delete(s.dms, r.id)
+	writeJSON(w, s.renderDM(dm))
+}
+
+func (s *Server) tos(w http.ResponseWriter, r *request) {
+	writeJSON(w, map[string]string{"tos": s.TOS})
+}
+
+func (s *Server) privacy(w http.ResponseWriter, r *request) {
+	writeJSON(w, map[string]string{"privacy": s.Privacy})
+}
+
+func (s *Server) requestToken(w http.ResponseWriter, r *request) {
+	callback := r.oauth["oauth_callback"]
+
+	key, secret := randomToken(), randomToken()
+	s.requestTokens[key] = &token{secret: secret, callback: callback}
+
+	v := url.Values{}
+	v.Set("oauth_token", key)
+	v.Set("oauth_token_secret", secret)
+	v.Set("oauth_callback_confirmed", strconv.FormatBool(callback != ""))
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	fmt.Fprint(w, v.Encode())
+}
+
+func (s *Server) accessToken(w http.ResponseWriter, r *request) {
+	key := r.oauth["oauth_token"]
+	tok := s.requestTokens[key]
+	if tok.verifier == "" || r.oauth["oauth_verifier"] != tok.verifier {
+		writeError(w, http.StatusUnauthorized, 89, "Invalid or expired token.")
+		return
+	}
+	delete(s.requestTokens, key)
+
+	access := &token{secret: randomToken(), userId: tok.userId}
+	accessKey := fmt.Sprintf("%d-%s", tok.userId, randomToken())
+	s.accessTokens[accessKey] = access
+
+	v := url.Values{}
+	v.Set("oauth_token", accessKey)
+	v.Set("oauth_token_secret", access.secret)
+	v.Set("user_id", strconv.FormatInt(tok.userId, 10))
+	v.Set("screen_name", s.users[tok.userId].ScreenName)
+	w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+	fmt.Fprint(w, v.Encode())
+}
+
+func (s *Server) bearerToken(w http.ResponseWriter, r *request) {
+	if r.param("grant_type") != "client_credentials" {
+		writeError(w, http.StatusForbidden, 170, "Missing required parameter: grant_type")
+		return
+	}
+
+	// the same token is returned until it is invalidated
+	for tok := range s.bearerTokens {
+		writeJSON(w, map[string]string{"token_type": "bearer", "access_token": tok})
+		return
+	}
+
+	tok := randomToken()
+	s.bearerTokens[tok] = true
+	writeJSON(w, map[string]string{"token_type": "bearer", "access_token": tok})
+}
+
+func (s *Server) invalidateBearerToken(w http.ResponseWriter, r *request) {
+	tok := r.param("access_token")
+	if !s.bearerTokens[tok] {
+		writeError(w, http.StatusForbidden, 89, "Invalid or expired token.")
+		return
+	}
+
+	delete(s.bearerTokens, tok)
+	writeJSON(w, map[string]string{"access_token": tok})
+}
+
+func (s *Server) oauth2Token(w http.ResponseWriter, r *request) {
+	var userId int64
+
+	switch r.param("grant_type") {
+	case "authorization_code":
+		code, ok := s.authCodes[r.param("code")]
+		if !ok || code.redirectUri != r.param("redirect_uri") || !verifyChallenge(code, r.param("code_verifier")) {
+			writeOAuth2Error(w, "invalid_grant", "Value passed for the authorization code was invalid.")
+			return
+		}
+		delete(s.authCodes, r.param("code"))
+		userId = code.userId
+
+	case "refresh_token":
+		id, ok := s.refreshTokens[r.param("refresh_token")]
+		if !ok {
+			writeOAuth2Error(w, "invalid_request", "Value passed for the token was invalid.")
+			return
+		}
+		delete(s.refreshTokens, r.param("refresh_token"))
+		userId = id
+
+	default:
+		writeOAuth2Error(w, "unsupported_grant_type", "Unsupported grant type.")
+		return
+	}
+
+	access, refresh := randomToken(), randomToken()
+	s.oauth2Tokens[access] = &oauth2Token{userId: userId, expiry: time.Now().Add(s.OAuth2TokenLifetime)}
+	s.refreshTokens[refresh] = userId
+
+	writeJSON(w, map[string]interface{}{
+		"token_type":    "bearer",
+		"expires_in":    int64(s.OAuth2TokenLifetime / time.Second),
+		"access_token":  access,
+		"refresh_token": refresh,
+		"scope":         "tweet.read tweet.write users.read offline.access",
+	})
+}
+
+// Finds the user named by the user_id or screen_name parameter
+func (s *Server) lookupUser(r *request) (u *User, ok bool) {
+	if str := r.param("user_id"); str != "" {
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return
+		}
+		u, ok = s.users[id]
+		return
+	}
+
+	id, ok := s.screenNames[strings.ToLower(r.param("screen_name"))]
+	if !ok {
+		return
+	}
+	u, ok = s.users[id]
+	return
+}
+
+func (s *Server) follow(userId, friendId int64) {
+	for _, id := range s.friends[userId] {
+		if id == friendId {
+			return
+		}
+	}
+	s.friends[userId] = append(s.friends[userId], friendId)
+}
+
+// Returns the number of users following userId
+func (s *Server) followerCount(userId int64) int {
+	return len(s.followers(userId))
+}
+
+// Returns the ids of users following userId, in ascending order
+func (s *Server) followers(userId int64) []int64 {
+	ids := []int64{}
+	for followerId, friends := range s.friends {
+		for _, id := range friends {
+			if id == userId {
+				ids = append(ids, followerId)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (s *Server) timeline(userId int64) []Tweet {
+	tweets := []Tweet{}
+	for _, tw := range s.allTweets() {
+		if tw.User.Id == userId {
+			tweets = append(tweets, tw)
+		}
+	}
+	return tweets
+}
+
+// Returns all tweets, newest first
+func (s *Server) allTweets() []Tweet {
+	tweets := make([]Tweet, 0, len(s.tweets))
+	for _, tw := range s.tweets {
+		tweets = append(tweets, s.render(tw))
+	}
+	sort.Sort(byTweetId(tweets))
+	return tweets
+}
+
+// Returns a copy of tw with its users filled in
+func (s *Server) render(tw *Tweet) Tweet {
+	out := *tw
+	out.User = s.renderUser(tw.User.Id)
+	if tw.RetweetedStatus != nil {
+		rt := s.render(tw.RetweetedStatus)
+		out.RetweetedStatus = &rt
+	}
+	return out
+}
+
+func (s *Server) renderUser(id int64) User {
+	u, ok := s.users[id]
+	if !ok {
+		return User{Id: id, IdStr: strconv.FormatInt(id, 10)}
+	}
+
+	out := *u
+	out.FriendsCount = len(s.friends[id])
+	out.FollowersCount = s.followerCount(id)
+	return out
+}
+
+func (s *Server) renderDM(dm *DirectMessage) DirectMessage {
+	out := *dm
+	out.IdStr = strconv.FormatInt(dm.Id, 10)
+	out.Sender = s.renderUser(dm.SenderId)
+	out.SenderScreenName = out.Sender.ScreenName
+	out.Recipient = s.renderUser(dm.RecipientId)
+	out.RecipientScreenName = out.Recipient.ScreenName
+	return out
+}
+
+func (s *Server) newId() int64 {
+	for {
+		s.nextId++
+		if _, ok := s.tweets[s.nextId]; ok {
+			continue
+		}
+		if _, ok := s.users[s.nextId]; ok {
+			continue
+		}
+		if _, ok := s.dms[s.nextId]; ok {
+			continue
+		}
+		if _, ok := s.media[s.nextId]; ok {
+			continue
+		}
+		return s.nextId
+	}
+}
+
+type byTweetId []Tweet
+
+func (t byTweetId) Len() int           { return len(t) }
+func (t byTweetId) Less(i, j int) bool { return t[i].Id > t[j].Id }
+func (t byTweetId) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+type byDMId []DirectMessage
+
+func (d byDMId) Len() int           { return len(d) }
+func (d byDMId) Less(i, j int) bool { return d[i].Id > d[j].Id }
+func (d byDMId) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func createdAt() string {
+	return time.Now().UTC().Format(time.RubyDate)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Writes an RFC 6749 error response
+func writeOAuth2Error(w http.ResponseWriter, code, description string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// Writes a Twitter-style {"errors": [...]} response
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{
+			{"code": code, "message": message},
+		},
+	})
+}
+
+func writeInjected(w http.ResponseWriter, e Error) {
+	for k, v := range e.Header {
+		w.Header()[k] = v
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusInternalServerError
+	}
+
+	if e.Body != "" || (e.Code == 0 && e.Message == "") {
+		w.WriteHeader(e.Status)
+		fmt.Fprint(w, e.Body)
+		return
+	}
+	writeError(w, e.Status, e.Code, e.Message)
+}
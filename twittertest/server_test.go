@@ -0,0 +1,155 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	consumerKey    = "key"
+	consumerSecret = "secret"
+	oauthToken     = "token"
+	oauthSecret    = "tokensecret"
+)
+
+func newServer() (s *Server, user User) {
+	s = NewServer(consumerKey, consumerSecret)
+	user = s.AddUser(User{ScreenName: "bsdf"})
+	s.AddToken(oauthToken, oauthSecret, user.Id)
+	return
+}
+
+// Sends a request to s signed with the test credentials
+func send(s *Server, method, path, nonce, secret string) (*http.Response, error) {
+	oauth := map[string]string{
+		"oauth_consumer_key":     consumerKey,
+		"oauth_nonce":            nonce,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "1318622958",
+		"oauth_token":            oauthToken,
+		"oauth_version":          "1.0",
+	}
+
+	req, _ := http.NewRequest(method, s.URL+path, nil)
+	r := &request{Request: req, form: url.Values{}}
+	oauth["oauth_signature"] = sign(signatureBase(r, oauth), consumerSecret, secret)
+
+	var keys, params []string
+	for k := range oauth {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, fmt.Sprintf(`%s="%s"`, k, encode(oauth[k])))
+	}
+
+	req.Header.Set("Authorization", "OAuth "+strings.Join(params, ", "))
+	return http.DefaultClient.Do(req)
+}
+
+func TestSignedRequest(t *testing.T) {
+	s, user := newServer()
+	defer s.Close()
+
+	resp, err := send(s, "GET", "/1.1/users/show.json?screen_name=bsdf", "nonce1", oauthSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected 200 for signed request, got %d", resp.StatusCode)
+	}
+
+	var u User
+	json.NewDecoder(resp.Body).Decode(&u)
+	if u.Id != user.Id {
+		t.Errorf("Expected user %d, got %d", user.Id, u.Id)
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	s, _ := newServer()
+	defer s.Close()
+
+	resp, err := send(s, "GET", "/1.1/help/tos.json", "nonce1", "wrongsecret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 401 {
+		t.Errorf("Expected 401 for bad signature, got %d", resp.StatusCode)
+	}
+}
+
+func TestReusedNonce(t *testing.T) {
+	s, _ := newServer()
+	defer s.Close()
+
+	for i, expected := range []int{200, 401} {
+		resp, err := send(s, "GET", "/1.1/help/tos.json", "nonce1", oauthSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Request %d: expected %d, got %d", i, expected, resp.StatusCode)
+		}
+	}
+}
+
+func TestInjectError(t *testing.T) {
+	s, _ := newServer()
+	defer s.Close()
+
+	s.InjectError("/1.1/help/tos.json", Error{Status: 503, Body: "Over capacity"})
+
+	resp, err := send(s, "GET", "/1.1/help/tos.json", "nonce1", oauthSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != 503 || string(body) != "Over capacity" {
+		t.Errorf("Injected error was not returned, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = send(s, "GET", "/1.1/help/tos.json", "nonce2", oauthSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		t.Errorf("Injected error was returned more than once, got %d", resp.StatusCode)
+	}
+
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Expected 2 recorded requests, got %d", n)
+	}
+}
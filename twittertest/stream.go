@@ -0,0 +1,232 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twittertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A connected stream
+type subscription struct {
+	match     func(Tweet) bool
+	delimited bool
+	messages  chan []byte
+	closed    chan struct{}
+}
+
+// Sends v, marshalled as JSON, to every connected stream, eg. to
+// simulate delete or limit notices
+func (s *Server) StreamMessage(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.streams {
+		s.send(sub, b)
+	}
+}
+
+// Disconnects every connected stream, as Twitter does when it restarts
+func (s *Server) DisconnectStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sub := range s.streams {
+		close(sub.closed)
+		delete(s.streams, sub)
+	}
+}
+
+// Returns the number of connected streams
+func (s *Server) Streams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.streams)
+}
+
+// Opens a stream of tweets matching the track, follow and locations
+// parameters
+func (s *Server) filterStream(w http.ResponseWriter, r *request) {
+	var track [][]string
+	for _, phrase := range strings.Split(r.param("track"), ",") {
+		if words := strings.Fields(strings.ToLower(phrase)); len(words) > 0 {
+			track = append(track, words)
+		}
+	}
+
+	follow := map[int64]bool{}
+	for _, str := range strings.Split(r.param("follow"), ",") {
+		if str == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			writeText(w, http.StatusNotAcceptable, "Parameter follow has unparseable items "+str)
+			return
+		}
+		follow[id] = true
+	}
+
+	// tweets here have no coordinates, so locations never match
+	locations := r.param("locations")
+
+	if len(track) == 0 && len(follow) == 0 && locations == "" {
+		writeText(w, http.StatusNotAcceptable, "No filter parameters found. Expect at least one parameter: follow track locations")
+		return
+	}
+
+	match := func(tw Tweet) bool {
+		if follow[tw.User.Id] {
+			return true
+		}
+
+		text := strings.ToLower(tw.Text)
+	phrases:
+		for _, words := range track {
+			for _, word := range words {
+				if !strings.Contains(text, word) {
+					continue phrases
+				}
+			}
+			return true
+		}
+		return false
+	}
+
+	r.stream = s.subscribe(match, r.param("delimited") == "length")
+}
+
+// Opens a stream of every new tweet
+func (s *Server) sampleStream(w http.ResponseWriter, r *request) {
+	all := func(Tweet) bool { return true }
+	r.stream = s.subscribe(all, r.param("delimited") == "length")
+}
+
+// Registers a stream. s.mu must be held.
+func (s *Server) subscribe(match func(Tweet) bool, delimited bool) *subscription {
+	sub := &subscription{
+		match:     match,
+		delimited: delimited,
+		messages:  make(chan []byte, 1000),
+		closed:    make(chan struct{}),
+	}
+	s.streams[sub] = true
+	return sub
+}
+
+// Sends tw to the streams it matches. s.mu must be held.
+func (s *Server) publish(tw *Tweet) {
+	if len(s.streams) == 0 {
+		return
+	}
+
+	rendered := s.render(tw)
+	b, _ := json.Marshal(rendered)
+	for sub := range s.streams {
+		if sub.match(rendered) {
+			s.send(sub, b)
+		}
+	}
+}
+
+// Queues a message for sub, dropping it if sub has fallen too far
+// behind. s.mu must be held.
+func (s *Server) send(sub *subscription, b []byte) {
+	select {
+	case sub.messages <- b:
+	default:
+	}
+}
+
+// Writes messages to a connected stream until it is closed by either end
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, sub *subscription) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.streams, sub)
+		s.mu.Unlock()
+	}()
+
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flush()
+
+	var keepAlive <-chan time.Time
+	s.mu.Lock()
+	interval := s.StreamKeepAlive
+	s.mu.Unlock()
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	write := func(b []byte) {
+		if sub.delimited {
+			fmt.Fprintf(w, "%d\r\n", len(b)+2)
+		}
+		w.Write(b)
+		w.Write([]byte("\r\n"))
+	}
+
+	for {
+		select {
+		case b := <-sub.messages:
+			write(b)
+		case <-keepAlive:
+			w.Write([]byte("\r\n"))
+		case <-sub.closed:
+			// send what was queued before the disconnect
+			for len(sub.messages) > 0 {
+				write(<-sub.messages)
+			}
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flush()
+	}
+}
+
+// Writes a plain text error, as the streaming API does
+func writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
+
+// Disconnects any streams and shuts the server down
+func (s *Server) Close() {
+	s.DisconnectStreams()
+	s.Server.Close()
+}
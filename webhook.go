@@ -0,0 +1,229 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Largest webhook body accepted
+const maxWebhookBody = 10 << 20
+
+// Receives Account Activity API events at a registered webhook url.
+// It answers CRC challenges, rejects requests that aren't signed with
+// the consumer secret, and passes each event to its callback along with
+// the id of the subscribed user it is for.
+//
+//	h := t.NewWebhookHandler()
+//	h.OnFollow = func(forUserId string, e UserEvent) { ... }
+//	http.Handle("/webhooks/twitter", h)
+type WebhookHandler struct {
+	OnTweetCreate   func(forUserId string, tweet Tweet)
+	OnTweetDelete   func(forUserId string, e TweetDeleteEvent)
+	OnFavorite      func(forUserId string, e FavoriteEvent)
+	OnFollow        func(forUserId string, e UserEvent)
+	OnBlock         func(forUserId string, e UserEvent)
+	OnMute          func(forUserId string, e UserEvent)
+	OnDirectMessage func(forUserId string, e DirectMessageEvent)
+
+	consumerSecret string
+}
+
+// A user as sent with Account Activity follow, block, mute and direct
+// message events, which give ids as strings
+type ActivityUser struct {
+	Id              string
+	Name            string
+	ScreenName      string `json:"screen_name"`
+	Protected       bool
+	Verified        bool
+	FollowersCount  int    `json:"followers_count"`
+	FriendsCount    int    `json:"friends_count"`
+	ProfileImageUrl string `json:"profile_image_url_https"`
+}
+
+// A user liked a tweet
+type FavoriteEvent struct {
+	Id              string
+	CreatedAt       string `json:"created_at"`
+	TimestampMs     int64  `json:"timestamp_ms"`
+	FavoritedStatus Tweet  `json:"favorited_status"`
+	User            User
+}
+
+// One user followed, blocked or muted another, or undid it. Type is
+// "follow", "unfollow", "block", "unblock", "mute" or "unmute".
+type UserEvent struct {
+	Type             string
+	CreatedTimestamp string `json:"created_timestamp"`
+	Source           ActivityUser
+	Target           ActivityUser
+}
+
+// A direct message was sent or received
+type DirectMessageEvent struct {
+	Type             string
+	Id               string
+	CreatedTimestamp string `json:"created_timestamp"`
+	MessageCreate    struct {
+		Target struct {
+			RecipientId string `json:"recipient_id"`
+		}
+		SenderId    string `json:"sender_id"`
+		MessageData struct {
+			Text     string
+			Entities Entities
+		} `json:"message_data"`
+	} `json:"message_create"`
+
+	// Filled in from the users sent with the event
+	Sender    ActivityUser `json:"-"`
+	Recipient ActivityUser `json:"-"`
+}
+
+// A tweet was deleted. Stored copies of it should be removed.
+type TweetDeleteEvent struct {
+	Status struct {
+		Id     string
+		UserId string `json:"user_id"`
+	}
+	TimestampMs string `json:"timestamp_ms"`
+}
+
+// The events sent in one webhook request
+type activity struct {
+	ForUserId           string                  `json:"for_user_id"`
+	TweetCreateEvents   []Tweet                 `json:"tweet_create_events"`
+	TweetDeleteEvents   []TweetDeleteEvent      `json:"tweet_delete_events"`
+	FavoriteEvents      []FavoriteEvent         `json:"favorite_events"`
+	FollowEvents        []UserEvent             `json:"follow_events"`
+	BlockEvents         []UserEvent             `json:"block_events"`
+	MuteEvents          []UserEvent             `json:"mute_events"`
+	DirectMessageEvents []DirectMessageEvent    `json:"direct_message_events"`
+	Users               map[string]ActivityUser `json:"users"`
+}
+
+// Returns a WebhookHandler that verifies requests with the client's
+// consumer secret
+func (t *Twitter) NewWebhookHandler() *WebhookHandler {
+	return &WebhookHandler{consumerSecret: t.ConsumerSecret}
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		h.serveCRC(w, r)
+	case "POST":
+		h.serveEvents(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Answers a challenge-response check, which Twitter sends when the
+// webhook is registered and hourly after
+func (h *WebhookHandler) serveCRC(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("crc_token")
+	if token == "" {
+		http.Error(w, "missing crc_token", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]string{
+		"response_token": h.sign([]byte(token)),
+	})
+}
+
+func (h *WebhookHandler) serveEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	signature := r.Header.Get("X-Twitter-Webhooks-Signature")
+	if !hmac.Equal([]byte(signature), []byte(h.sign(body))) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var a activity
+	if err = json.Unmarshal(body, &a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.dispatch(a)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Passes each event to its callback
+func (h *WebhookHandler) dispatch(a activity) {
+	if h.OnTweetCreate != nil {
+		for _, tweet := range a.TweetCreateEvents {
+			h.OnTweetCreate(a.ForUserId, tweet)
+		}
+	}
+	if h.OnTweetDelete != nil {
+		for _, e := range a.TweetDeleteEvents {
+			h.OnTweetDelete(a.ForUserId, e)
+		}
+	}
+	if h.OnFavorite != nil {
+		for _, e := range a.FavoriteEvents {
+			h.OnFavorite(a.ForUserId, e)
+		}
+	}
+	if h.OnFollow != nil {
+		for _, e := range a.FollowEvents {
+			h.OnFollow(a.ForUserId, e)
+		}
+	}
+	if h.OnBlock != nil {
+		for _, e := range a.BlockEvents {
+			h.OnBlock(a.ForUserId, e)
+		}
+	}
+	if h.OnMute != nil {
+		for _, e := range a.MuteEvents {
+			h.OnMute(a.ForUserId, e)
+		}
+	}
+	if h.OnDirectMessage != nil {
+		for _, e := range a.DirectMessageEvents {
+			e.Sender = a.Users[e.MessageCreate.SenderId]
+			e.Recipient = a.Users[e.MessageCreate.Target.RecipientId]
+			h.OnDirectMessage(a.ForUserId, e)
+		}
+	}
+}
+
+// Returns the sha256= signature of b made with the consumer secret, as
+// used for CRC responses and webhook signatures
+func (h *WebhookHandler) sign(b []byte) string {
+	mac := hmac.New(sha256.New, []byte(h.consumerSecret))
+	mac.Write(b)
+	return "sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
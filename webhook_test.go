@@ -0,0 +1,164 @@
+// bsdf/twitter: an implementation of the twitter api in Go
+// Copyright (C) 2012, 2013 bsdf
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package twitter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const activityPayload = `{
+	"for_user_id": "76395009",
+	"tweet_create_events": [
+		{"id": 221281838440783875, "id_str": "221281838440783875", "text": "listening to gucci mane", "user": {"id": 76395009, "screen_name": "MEMEMEMEMES"}}
+	],
+	"tweet_delete_events": [
+		{"status": {"id": "601430178305220608", "user_id": "3198576760"}, "timestamp_ms": "1432228155593"}
+	],
+	"favorite_events": [
+		{"id": "a7ba59eab0bfcba386f7acedac279542", "created_at": "Mon Mar 26 16:33:26 +0000 2018", "timestamp_ms": 1522082006140,
+		 "favorited_status": {"id": 221281838440783875, "text": "listening to gucci mane"},
+		 "user": {"id": 14114455, "screen_name": "bsdf"}}
+	],
+	"follow_events": [
+		{"type": "follow", "created_timestamp": "1517588749178",
+		 "source": {"id": "14114455", "screen_name": "bsdf"},
+		 "target": {"id": "76395009", "screen_name": "MEMEMEMEMES"}}
+	],
+	"block_events": [
+		{"type": "block", "created_timestamp": "1518127020304",
+		 "source": {"id": "76395009"}, "target": {"id": "3198576760"}}
+	],
+	"mute_events": [
+		{"type": "unmute", "created_timestamp": "1518127020304",
+		 "source": {"id": "76395009"}, "target": {"id": "14114455"}}
+	],
+	"direct_message_events": [
+		{"type": "message_create", "id": "954491830116155396", "created_timestamp": "1516403560557",
+		 "message_create": {"target": {"recipient_id": "76395009"}, "sender_id": "14114455",
+		                    "message_data": {"text": "Hello World!", "entities": {"hashtags": []}}}}
+	],
+	"users": {
+		"14114455": {"id": "14114455", "screen_name": "bsdf"},
+		"76395009": {"id": "76395009", "screen_name": "MEMEMEMEMES"}
+	}
+}`
+
+func webhookSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookCRC(t *testing.T) {
+	h := tw.NewWebhookHandler()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/webhook?crc_token=challenge", nil))
+
+	var response = struct {
+		ResponseToken string `json:"response_token"`
+	}{}
+	json.NewDecoder(w.Body).Decode(&response)
+
+	if expected := webhookSignature(config.ConsumerSecret, "challenge"); response.ResponseToken != expected {
+		t.Errorf("Expected response token %s, got %s", expected, response.ResponseToken)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/webhook", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a crc_token, got %d", w.Code)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	h := tw.NewWebhookHandler()
+	called := false
+	h.OnTweetCreate = func(string, Tweet) { called = true }
+
+	for _, signature := range []string{"", webhookSignature("wrong", activityPayload)} {
+		r := httptest.NewRequest("POST", "/webhook", strings.NewReader(activityPayload))
+		r.Header.Set("X-Twitter-Webhooks-Signature", signature)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for signature %q, got %d", signature, w.Code)
+		}
+	}
+
+	if called {
+		t.Error("Events with a bad signature were dispatched")
+	}
+}
+
+func TestWebhookEvents(t *testing.T) {
+	h := tw.NewWebhookHandler()
+
+	var forUsers []string
+	var tweet Tweet
+	var deleted TweetDeleteEvent
+	var favorite FavoriteEvent
+	var follow, block, mute UserEvent
+	var dm DirectMessageEvent
+
+	h.OnTweetCreate = func(forUserId string, t Tweet) { forUsers = append(forUsers, forUserId); tweet = t }
+	h.OnTweetDelete = func(_ string, e TweetDeleteEvent) { deleted = e }
+	h.OnFavorite = func(_ string, e FavoriteEvent) { favorite = e }
+	h.OnFollow = func(_ string, e UserEvent) { follow = e }
+	h.OnBlock = func(_ string, e UserEvent) { block = e }
+	h.OnMute = func(_ string, e UserEvent) { mute = e }
+	h.OnDirectMessage = func(_ string, e DirectMessageEvent) { dm = e }
+
+	r := httptest.NewRequest("POST", "/webhook", strings.NewReader(activityPayload))
+	r.Header.Set("X-Twitter-Webhooks-Signature", webhookSignature(config.ConsumerSecret, activityPayload))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", w.Code, w.Body)
+	}
+
+	if len(forUsers) != 1 || forUsers[0] != "76395009" || tweet.Text != "listening to gucci mane" {
+		t.Errorf("Unexpected tweet_create event for %v: %+v", forUsers, tweet)
+	}
+	if deleted.Status.Id != "601430178305220608" || deleted.Status.UserId != "3198576760" {
+		t.Errorf("Unexpected tweet_delete event %+v", deleted)
+	}
+	if favorite.FavoritedStatus.Id != 221281838440783875 || favorite.User.ScreenName != "bsdf" {
+		t.Errorf("Unexpected favorite event %+v", favorite)
+	}
+	if follow.Type != "follow" || follow.Source.ScreenName != "bsdf" || follow.Target.Id != "76395009" {
+		t.Errorf("Unexpected follow event %+v", follow)
+	}
+	if block.Type != "block" || block.Target.Id != "3198576760" {
+		t.Errorf("Unexpected block event %+v", block)
+	}
+	if mute.Type != "unmute" || mute.Target.Id != "14114455" {
+		t.Errorf("Unexpected mute event %+v", mute)
+	}
+	if dm.MessageCreate.MessageData.Text != "Hello World!" || dm.Sender.ScreenName != "bsdf" || dm.Recipient.ScreenName != "MEMEMEMEMES" {
+		t.Errorf("Unexpected direct message event %+v", dm)
+	}
+}